@@ -0,0 +1,236 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrBatchUnsupported is returned when writing a batch through a Storager
+// that does not implement Batcher.
+var ErrBatchUnsupported = errors.New("storager does not support Batch")
+
+// BatchOp is a write of a batch: a Save of Value, or a Delete of Key when
+// Delete is set.
+type BatchOp struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// Batcher is implemented by the Storagers able to apply several writes
+// atomically: readers, and the store after a crash, see either every op of a
+// batch or none of them. When a batch writes a key more than once, the last
+// op wins. Wrappers implement it by batching through the wrapped Storager,
+// returning ErrBatchUnsupported when it cannot.
+type Batcher interface {
+	Batch(ops []BatchOp) error
+}
+
+// ContextBatcher is a Batcher whose calls honor the cancellation and
+// deadline of a context.
+type ContextBatcher interface {
+	BatchContext(ctx context.Context, ops []BatchOp) error
+}
+
+// Batch applies ops atomically on s.
+func Batch(s Storager, ops []BatchOp) error {
+	return BatchContext(context.Background(), s, ops)
+}
+
+// BatchContext applies ops atomically on s, adapting a Batcher that takes
+// no context as WithContext does.
+func BatchContext(ctx context.Context, s Storager, ops []BatchOp) error {
+	if cb, ok := s.(ContextBatcher); ok {
+		return cb.BatchContext(ctx, ops)
+	}
+
+	b, ok := s.(Batcher)
+	if !ok {
+		return ErrBatchUnsupported
+	}
+
+	return runContext(ctx, func() error {
+		return b.Batch(ops)
+	})
+}
+
+func isBatcher(s Storager) bool {
+	switch s.(type) {
+	case Batcher, ContextBatcher:
+		return true
+	}
+
+	return false
+}
+
+// WriteBatch is a Storager collecting the Saves and Deletes made through it
+// until Commit writes them to the wrapped Storager in a single batch. Loads
+// see the writes collected so far. It is safe for concurrent use.
+type WriteBatch struct {
+	Storager Storager
+
+	lock    sync.Mutex
+	ops     []BatchOp
+	written map[string]int
+}
+
+func NewWriteBatch(s Storager) *WriteBatch {
+	return &WriteBatch{
+		Storager: s,
+		written:  map[string]int{},
+	}
+}
+
+func (wb *WriteBatch) add(op BatchOp) {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+
+	wb.written[string(op.Key)] = len(wb.ops)
+	wb.ops = append(wb.ops, op)
+}
+
+func (wb *WriteBatch) Save(k, v []byte) error {
+	wb.add(BatchOp{
+		Key:   append([]byte(nil), k...),
+		Value: append([]byte{}, v...),
+	})
+
+	return nil
+}
+
+func (wb *WriteBatch) wrapped() []Storager {
+	return []Storager{wb.Storager}
+}
+
+// Delete returns ErrDeleteUnsupported when the wrapped Storager cannot
+// delete.
+func (wb *WriteBatch) Delete(k []byte) error {
+	if !isDeleter(wb.Storager) {
+		return ErrDeleteUnsupported
+	}
+
+	wb.add(BatchOp{Key: append([]byte(nil), k...), Delete: true})
+	return nil
+}
+
+func (wb *WriteBatch) Load(k []byte) ([]byte, error) {
+	return wb.LoadContext(context.Background(), k)
+}
+
+func (wb *WriteBatch) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	wb.lock.Lock()
+	i, ok := wb.written[string(k)]
+	var op BatchOp
+	if ok {
+		op = wb.ops[i]
+	}
+	wb.lock.Unlock()
+
+	if !ok {
+		return loadContext(ctx, wb.Storager, k)
+	}
+
+	if op.Delete {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte{}, op.Value...), nil
+}
+
+func (wb *WriteBatch) SaveContext(ctx context.Context, k, v []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return wb.Save(k, v)
+}
+
+func (wb *WriteBatch) DeleteContext(ctx context.Context, k []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return wb.Delete(k)
+}
+
+// Ops returns the writes collected so far, in order.
+func (wb *WriteBatch) Ops() []BatchOp {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+
+	return append([]BatchOp(nil), wb.ops...)
+}
+
+// Commit writes the collected ops, atomically when the wrapped Storager is a
+// Batcher and one by one when it is not, and empties the batch.
+func (wb *WriteBatch) Commit() error {
+	return wb.CommitContext(context.Background())
+}
+
+func (wb *WriteBatch) CommitContext(ctx context.Context) error {
+	wb.lock.Lock()
+	ops := wb.ops
+	wb.ops, wb.written = nil, map[string]int{}
+	wb.lock.Unlock()
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	err := BatchContext(ctx, wb.Storager, ops)
+	if !errors.Is(err, ErrBatchUnsupported) {
+		return err
+	}
+
+	for _, op := range ops {
+		if op.Delete {
+			err = DeleteContext(ctx, wb.Storager, op.Key)
+		} else {
+			err = saveContext(ctx, wb.Storager, op.Key, op.Value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rollback drops the collected ops.
+func (wb *WriteBatch) Rollback() {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+
+	wb.ops, wb.written = nil, map[string]int{}
+}
+
+// batched runs fn against a WriteBatch committed once fn succeeded when s is
+// a Batcher, and against s itself otherwise.
+func batched(ctx context.Context, s Storager, fn func(Storager) error) error {
+	if !isBatcher(s) {
+		return fn(s)
+	}
+
+	wb := NewWriteBatch(s)
+	if err := fn(wb); err != nil {
+		return err
+	}
+
+	return wb.CommitContext(ctx)
+}
+
+// mapBatch returns a copy of ops with fn applied to every op, for the
+// wrappers rewriting keys or values.
+func mapBatch(ops []BatchOp, fn func(op BatchOp) (BatchOp, error)) ([]BatchOp, error) {
+	mapped := make([]BatchOp, len(ops))
+	for i, op := range ops {
+		var err error
+		if mapped[i], err = fn(op); err != nil {
+			return nil, err
+		}
+	}
+
+	return mapped, nil
+}
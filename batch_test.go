@@ -0,0 +1,152 @@
+package persistent
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingPersistent is a struct field that always fails to persist.
+type failingPersistent struct{}
+
+func (*failingPersistent) Persist(Storager, []byte) error {
+	return errors.New("cannot persist")
+}
+
+func (*failingPersistent) Restore(Storager, []byte) error {
+	return errors.New("cannot restore")
+}
+
+func TestWriteBatch(t *testing.T) {
+	storager := NewMemoryStorager()
+	storager.Save([]byte("a"), []byte("1"))
+
+	wb := NewWriteBatch(storager)
+	wb.Save([]byte("b"), []byte("2"))
+	if err := wb.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wb.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the batch to see its delete, got %v", err)
+	}
+
+	if dt, err := wb.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected the batch to see its save, got %q, %v", dt, err)
+	}
+
+	if _, err := storager.Load([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected nothing written before Commit, got %v", err)
+	}
+
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected b to be saved, got %q, %v", dt, err)
+	}
+
+	wb.Save([]byte("c"), []byte("3"))
+	wb.Rollback()
+
+	if err := wb.Commit(); err != nil || storager.Len() != 1 {
+		t.Fatalf("expected the rolled back save to be dropped, got %d keys, %v", storager.Len(), err)
+	}
+}
+
+func TestWriteBatchFallback(t *testing.T) {
+	storager := newTestDeleter(map[string][]byte{"a": []byte("1")})
+
+	if err := Batch(storager, []BatchOp{{Key: []byte("b")}}); !errors.Is(err, ErrBatchUnsupported) {
+		t.Fatalf("expected ErrBatchUnsupported, got %v", err)
+	}
+
+	wb := NewWriteBatch(storager)
+	wb.Save([]byte("b"), []byte("2"))
+	wb.Delete([]byte("a"))
+
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := storager.db["a"]; ok || string(storager.db["b"]) != "2" {
+		t.Fatalf("expected the ops to be written one by one, got %v", storager.db)
+	}
+
+	wb = NewWriteBatch(&testStorager{map[string][]byte{}})
+	if err := wb.Delete([]byte("a")); !errors.Is(err, ErrDeleteUnsupported) {
+		t.Fatalf("expected ErrDeleteUnsupported, got %v", err)
+	}
+}
+
+func TestPersistStructBatch(t *testing.T) {
+	storager := NewFaultyStorager(NewMemoryStorager())
+
+	type person struct {
+		Name   *PersistentString
+		Tags   *PersistentSlice
+		Broken *failingPersistent
+	}
+
+	failures := 0
+	PersistStruct("p", &person{
+		Name:   NewPersistentString("Carlos"),
+		Tags:   &PersistentSlice{NewPersistentString("a"), NewPersistentString("b")},
+		Broken: &failingPersistent{},
+	}, storager, func(e error) {
+		if e != nil {
+			failures++
+		}
+	})
+
+	if failures != 1 {
+		t.Fatalf("expected the broken field to fail, got %d failures", failures)
+	}
+
+	if n := storager.Storager.(*MemoryStorager).Len(); n != 0 {
+		t.Fatalf("expected nothing to be written, got %d keys", n)
+	}
+
+	type tagged struct {
+		Name *PersistentString
+		Tags *PersistentSlice
+	}
+
+	PersistStruct("p", &tagged{
+		Name: NewPersistentString("Carlos"),
+		Tags: &PersistentSlice{NewPersistentString("a"), NewPersistentString("b")},
+	}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	batches := 0
+	for _, call := range storager.Calls() {
+		switch call.Op {
+		case FaultBatch:
+			batches++
+		case FaultSave, FaultDelete:
+			t.Fatalf("unexpected %s of %q outside of the batch", call.Op, call.Key)
+		}
+	}
+
+	if batches != 1 {
+		t.Fatalf("expected the struct and its slice in a single batch, got %d", batches)
+	}
+
+	restored := &tagged{}
+	RestoreStruct("p", restored, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *restored.Name != "Carlos" || len(*restored.Tags) != 2 {
+		t.Fatalf("unexpected struct %v, %v", *restored.Name, *restored.Tags)
+	}
+}
@@ -0,0 +1,833 @@
+package persistent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	btreePageSize  = 4096
+	btreeMaxInline = btreePageSize / 4
+	btreeMagic     = "PBTREE01"
+
+	// crc32 | payload length | node type | entry count
+	btreeNodeHeaderSize = 4 + 4 + 1 + 4
+)
+
+const (
+	btreeLeafNode = byte(iota + 1)
+	btreeBranchNode
+)
+
+const (
+	btreeInlineValue = byte(iota)
+	btreeOverflowValue
+)
+
+// btreePtr addresses a run of consecutive pages. A zero pages count is the
+// empty tree.
+type btreePtr struct {
+	page  uint64
+	pages uint32
+}
+
+type btreeValue struct {
+	overflow bool
+	inline   []byte
+	ptr      btreePtr
+	size     uint64
+}
+
+type btreeNode struct {
+	leaf     bool
+	keys     [][]byte
+	values   []btreeValue
+	children []btreePtr
+}
+
+// BTreeStorager is a pure Go B+tree kept in a single page based file. Pages
+// are never modified in place: a Save writes new copies of the path from the
+// leaf to the root and then switches between two meta pages to publish the
+// new root, so a crash always leaves the last committed tree intact. Keys are
+// kept in order and can be iterated with Range.
+type BTreeStorager struct {
+	// NoSync skips the fsyncs around the root switch. It is faster but a
+	// crash may lose recent Saves.
+	NoSync bool
+
+	lock      sync.RWMutex
+	file      *os.File
+	txid      uint64
+	root      btreePtr
+	pageCount uint64
+	free      []uint64
+	pending   []uint64
+}
+
+func OpenBTreeStorager(path string) (*BTreeStorager, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := &BTreeStorager{file: file}
+	if err := bs.open(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return bs, nil
+}
+
+func (bs *BTreeStorager) open() error {
+	info, err := bs.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		bs.pageCount = 2
+		for i := 0; i < 2; i++ {
+			if err := bs.writeMeta(uint64(i)); err != nil {
+				return err
+			}
+		}
+
+		return bs.file.Sync()
+	}
+
+	found := false
+	for i := int64(0); i < 2; i++ {
+		page := make([]byte, btreePageSize)
+		if _, err := bs.file.ReadAt(page, i*btreePageSize); err != nil {
+			continue
+		}
+
+		txid, root, pageCount, ok := decodeBTreeMeta(page)
+		if !ok || found && txid < bs.txid {
+			continue
+		}
+
+		found = true
+		bs.txid = txid
+		bs.root = root
+		bs.pageCount = pageCount
+	}
+
+	if !found {
+		return errors.New("no valid b+tree meta page")
+	}
+
+	reachable := map[uint64]bool{}
+	if err := bs.walk(bs.root, reachable); err != nil {
+		return err
+	}
+
+	for p := uint64(2); p < bs.pageCount; p++ {
+		if !reachable[p] {
+			bs.free = append(bs.free, p)
+		}
+	}
+
+	return nil
+}
+
+func (bs *BTreeStorager) walk(ptr btreePtr, reachable map[uint64]bool) error {
+	if ptr.pages == 0 {
+		return nil
+	}
+
+	markBTreePages(ptr, reachable)
+
+	n, err := bs.readNode(ptr)
+	if err != nil {
+		return err
+	}
+
+	for i := range n.keys {
+		if !n.leaf {
+			if err := bs.walk(n.children[i], reachable); err != nil {
+				return err
+			}
+		} else if n.values[i].overflow {
+			markBTreePages(n.values[i].ptr, reachable)
+		}
+	}
+
+	return nil
+}
+
+func markBTreePages(ptr btreePtr, reachable map[uint64]bool) {
+	for i := uint64(0); i < uint64(ptr.pages); i++ {
+		reachable[ptr.page+i] = true
+	}
+}
+
+func decodeBTreeMeta(page []byte) (uint64, btreePtr, uint64, bool) {
+	if string(page[:8]) != btreeMagic {
+		return 0, btreePtr{}, 0, false
+	}
+
+	if crc32.ChecksumIEEE(page[12:40]) != binary.LittleEndian.Uint32(page[8:]) {
+		return 0, btreePtr{}, 0, false
+	}
+
+	txid := binary.LittleEndian.Uint64(page[12:])
+	root := btreePtr{
+		page:  binary.LittleEndian.Uint64(page[20:]),
+		pages: binary.LittleEndian.Uint32(page[28:]),
+	}
+
+	return txid, root, binary.LittleEndian.Uint64(page[32:]), true
+}
+
+func (bs *BTreeStorager) writeMeta(txid uint64) error {
+	page := make([]byte, btreePageSize)
+	copy(page, btreeMagic)
+	binary.LittleEndian.PutUint64(page[12:], txid)
+	binary.LittleEndian.PutUint64(page[20:], bs.root.page)
+	binary.LittleEndian.PutUint32(page[28:], bs.root.pages)
+	binary.LittleEndian.PutUint64(page[32:], bs.pageCount)
+	binary.LittleEndian.PutUint32(page[8:], crc32.ChecksumIEEE(page[12:40]))
+
+	_, err := bs.file.WriteAt(page, int64(txid%2)*btreePageSize)
+	return err
+}
+
+func (bs *BTreeStorager) readPages(ptr btreePtr) ([]byte, error) {
+	dt := make([]byte, int64(ptr.pages)*btreePageSize)
+	if _, err := bs.file.ReadAt(dt, int64(ptr.page)*btreePageSize); err != nil {
+		return nil, err
+	}
+
+	return dt, nil
+}
+
+func (bs *BTreeStorager) readNode(ptr btreePtr) (*btreeNode, error) {
+	dt, err := bs.readPages(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(dt[4:])
+	if uint64(length)+btreeNodeHeaderSize > uint64(len(dt)) ||
+		crc32.ChecksumIEEE(dt[4:btreeNodeHeaderSize+length]) != binary.LittleEndian.Uint32(dt) {
+
+		return nil, fmt.Errorf("b+tree page %d is corrupted", ptr.page)
+	}
+
+	n := &btreeNode{leaf: dt[8] == btreeLeafNode}
+	count := binary.LittleEndian.Uint32(dt[9:])
+	buff := bytes.NewReader(dt[btreeNodeHeaderSize : btreeNodeHeaderSize+length])
+
+	for i := uint32(0); i < count; i++ {
+		k, err := readBTreeBytes(buff)
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, k)
+
+		if !n.leaf {
+			var child btreePtr
+			if err := readBTreePtr(buff, &child); err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, child)
+			continue
+		}
+
+		kind, err := buff.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		var v btreeValue
+		if kind == btreeOverflowValue {
+			v.overflow = true
+			if err := readBTreePtr(buff, &v.ptr); err != nil {
+				return nil, err
+			}
+			if err := binary.Read(buff, binary.LittleEndian, &v.size); err != nil {
+				return nil, err
+			}
+		} else if v.inline, err = readBTreeBytes(buff); err != nil {
+			return nil, err
+		}
+
+		n.values = append(n.values, v)
+	}
+
+	return n, nil
+}
+
+func readBTreeBytes(buff *bytes.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	if l > uint64(buff.Len()) {
+		return nil, errors.New("b+tree entry exceeds its page")
+	}
+
+	dt := make([]byte, l)
+	_, err = io.ReadFull(buff, dt)
+	return dt, err
+}
+
+func readBTreePtr(buff *bytes.Reader, ptr *btreePtr) error {
+	if err := binary.Read(buff, binary.LittleEndian, &ptr.page); err != nil {
+		return err
+	}
+
+	return binary.Read(buff, binary.LittleEndian, &ptr.pages)
+}
+
+func (n *btreeNode) entry(i int) []byte {
+	buff := new(bytes.Buffer)
+	lBuff := make([]byte, binary.MaxVarintLen64)
+
+	buff.Write(lBuff[:binary.PutUvarint(lBuff, uint64(len(n.keys[i])))])
+	buff.Write(n.keys[i])
+
+	if !n.leaf {
+		binary.Write(buff, binary.LittleEndian, n.children[i].page)
+		binary.Write(buff, binary.LittleEndian, n.children[i].pages)
+		return buff.Bytes()
+	}
+
+	v := n.values[i]
+	if v.overflow {
+		buff.WriteByte(btreeOverflowValue)
+		binary.Write(buff, binary.LittleEndian, v.ptr.page)
+		binary.Write(buff, binary.LittleEndian, v.ptr.pages)
+		binary.Write(buff, binary.LittleEndian, v.size)
+	} else {
+		buff.WriteByte(btreeInlineValue)
+		buff.Write(lBuff[:binary.PutUvarint(lBuff, uint64(len(v.inline)))])
+		buff.Write(v.inline)
+	}
+
+	return buff.Bytes()
+}
+
+func (n *btreeNode) slice(from, to int) *btreeNode {
+	s := &btreeNode{leaf: n.leaf, keys: n.keys[from:to]}
+	if n.leaf {
+		s.values = n.values[from:to]
+	} else {
+		s.children = n.children[from:to]
+	}
+
+	return s
+}
+
+// split breaks a node that does not fit in a page in halves of similar
+// encoded size. A single entry larger than a page keeps a node of its own.
+func (n *btreeNode) split() []*btreeNode {
+	entries := make([][]byte, len(n.keys))
+	size := 0
+	for i := range n.keys {
+		entries[i] = n.entry(i)
+		size += len(entries[i])
+	}
+
+	if size+btreeNodeHeaderSize <= btreePageSize || len(n.keys) < 2 {
+		return []*btreeNode{n}
+	}
+
+	half, acc, mid := size/2, 0, 1
+	for i := range entries[:len(entries)-1] {
+		acc += len(entries[i])
+		mid = i + 1
+		if acc >= half {
+			break
+		}
+	}
+
+	return append(n.slice(0, mid).split(), n.slice(mid, len(n.keys)).split()...)
+}
+
+func (bs *BTreeStorager) alloc(pages uint32) uint64 {
+	if pages == 1 && len(bs.free) > 0 {
+		p := bs.free[len(bs.free)-1]
+		bs.free = bs.free[:len(bs.free)-1]
+		return p
+	}
+
+	p := bs.pageCount
+	bs.pageCount += uint64(pages)
+	return p
+}
+
+func (bs *BTreeStorager) release(ptr btreePtr) {
+	for i := uint64(0); i < uint64(ptr.pages); i++ {
+		bs.pending = append(bs.pending, ptr.page+i)
+	}
+}
+
+func (bs *BTreeStorager) writePages(dt []byte) (btreePtr, error) {
+	pages := uint32((len(dt) + btreePageSize - 1) / btreePageSize)
+	if pages == 0 {
+		pages = 1
+	}
+
+	run := make([]byte, int64(pages)*btreePageSize)
+	copy(run, dt)
+
+	ptr := btreePtr{page: bs.alloc(pages), pages: pages}
+	_, err := bs.file.WriteAt(run, int64(ptr.page)*btreePageSize)
+	return ptr, err
+}
+
+func (bs *BTreeStorager) writeNode(n *btreeNode) (btreePtr, error) {
+	payload := new(bytes.Buffer)
+	for i := range n.keys {
+		payload.Write(n.entry(i))
+	}
+
+	dt := make([]byte, btreeNodeHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(dt[4:], uint32(payload.Len()))
+	dt[8] = btreeBranchNode
+	if n.leaf {
+		dt[8] = btreeLeafNode
+	}
+	binary.LittleEndian.PutUint32(dt[9:], uint32(len(n.keys)))
+	copy(dt[btreeNodeHeaderSize:], payload.Bytes())
+	binary.LittleEndian.PutUint32(dt, crc32.ChecksumIEEE(dt[4:]))
+
+	return bs.writePages(dt)
+}
+
+// writeSplit writes a node, splitting it when needed, and returns the nodes
+// that replace it as a branch node of their own.
+func (bs *BTreeStorager) writeSplit(n *btreeNode) (*btreeNode, error) {
+	parent := &btreeNode{}
+	for _, s := range n.split() {
+		ptr, err := bs.writeNode(s)
+		if err != nil {
+			return nil, err
+		}
+
+		parent.keys = append(parent.keys, s.keys[0])
+		parent.children = append(parent.children, ptr)
+	}
+
+	return parent, nil
+}
+
+// insert copies the path to k and returns the nodes replacing ptr.
+func (bs *BTreeStorager) insert(ptr btreePtr, k []byte, v btreeValue) (*btreeNode, error) {
+	n, err := bs.readNode(ptr)
+	if err != nil {
+		return nil, err
+	}
+	bs.release(ptr)
+
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], k) >= 0
+	})
+
+	if n.leaf {
+		if i < len(n.keys) && bytes.Equal(n.keys[i], k) {
+			if n.values[i].overflow {
+				bs.release(n.values[i].ptr)
+			}
+			n.values[i] = v
+		} else {
+			n.keys = append(n.keys[:i], append([][]byte{k}, n.keys[i:]...)...)
+			n.values = append(n.values[:i], append([]btreeValue{v}, n.values[i:]...)...)
+		}
+
+		return bs.writeSplit(n)
+	}
+
+	if i == len(n.keys) || !bytes.Equal(n.keys[i], k) {
+		if i > 0 {
+			i--
+		}
+	}
+
+	replace, err := bs.insert(n.children[i], k, v)
+	if err != nil {
+		return nil, err
+	}
+
+	n.keys = append(n.keys[:i], append(replace.keys, n.keys[i+1:]...)...)
+	n.children = append(n.children[:i], append(replace.children, n.children[i+1:]...)...)
+	return bs.writeSplit(n)
+}
+
+// update runs fn, which changes the tree without publishing it, and commits
+// the new root, or restores the last committed tree when anything failed.
+func (bs *BTreeStorager) update(fn func() error) error {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	if bs.file == nil {
+		return os.ErrClosed
+	}
+
+	root, pageCount := bs.root, bs.pageCount
+	free := append([]uint64(nil), bs.free...)
+
+	err := fn()
+	if err == nil && bs.root != root {
+		err = bs.commit()
+	}
+
+	if err != nil {
+		bs.root, bs.pageCount, bs.free, bs.pending = root, pageCount, free, nil
+	}
+
+	return err
+}
+
+func (bs *BTreeStorager) Save(k, v []byte) error {
+	return bs.update(func() error {
+		return bs.save(k, v)
+	})
+}
+
+func (bs *BTreeStorager) save(k, v []byte) error {
+	value := btreeValue{inline: v}
+	if len(v) > btreeMaxInline {
+		ptr, err := bs.writePages(v)
+		if err != nil {
+			return err
+		}
+
+		value = btreeValue{overflow: true, ptr: ptr, size: uint64(len(v))}
+	}
+
+	var root *btreeNode
+	var err error
+
+	if bs.root.pages == 0 {
+		root, err = bs.writeSplit(&btreeNode{
+			leaf:   true,
+			keys:   [][]byte{k},
+			values: []btreeValue{value},
+		})
+	} else {
+		root, err = bs.insert(bs.root, k, value)
+	}
+
+	for err == nil && len(root.keys) > 1 {
+		root, err = bs.writeSplit(root)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	bs.root = root.children[0]
+	return nil
+}
+
+// remove copies the path to k without it and returns the nodes replacing
+// ptr, none when ptr is left empty. Nothing is written when k is missing.
+func (bs *BTreeStorager) remove(ptr btreePtr, k []byte) (*btreeNode, bool, error) {
+	n, err := bs.readNode(ptr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], k) >= 0
+	})
+
+	if n.leaf {
+		if i == len(n.keys) || !bytes.Equal(n.keys[i], k) {
+			return nil, false, nil
+		}
+
+		bs.release(ptr)
+		if n.values[i].overflow {
+			bs.release(n.values[i].ptr)
+		}
+
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+	} else {
+		if i == len(n.keys) || !bytes.Equal(n.keys[i], k) {
+			if i == 0 {
+				return nil, false, nil
+			}
+			i--
+		}
+
+		replace, found, err := bs.remove(n.children[i], k)
+		if !found || err != nil {
+			return nil, found, err
+		}
+
+		bs.release(ptr)
+		n.keys = append(n.keys[:i:i], append(replace.keys, n.keys[i+1:]...)...)
+		n.children = append(n.children[:i:i], append(replace.children, n.children[i+1:]...)...)
+	}
+
+	if len(n.keys) == 0 {
+		return &btreeNode{}, true, nil
+	}
+
+	replace, err := bs.writeSplit(n)
+	return replace, true, err
+}
+
+// Delete removes k, collapsing the nodes it leaves empty. Nodes are not
+// merged, so a tree that shrank keeps its height.
+func (bs *BTreeStorager) Delete(k []byte) error {
+	return bs.update(func() error {
+		return bs.delete(k)
+	})
+}
+
+func (bs *BTreeStorager) delete(k []byte) error {
+	if bs.root.pages == 0 {
+		return nil
+	}
+
+	replace, found, err := bs.remove(bs.root, k)
+	if !found || err != nil {
+		return err
+	}
+
+	for err == nil && len(replace.keys) > 1 {
+		replace, err = bs.writeSplit(replace)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	bs.root = btreePtr{}
+	if len(replace.keys) == 1 {
+		bs.root = replace.children[0]
+	}
+
+	return nil
+}
+
+// Batch applies every op under a single root switch.
+func (bs *BTreeStorager) Batch(ops []BatchOp) error {
+	return bs.update(func() error {
+		for _, op := range ops {
+			var err error
+			if op.Delete {
+				err = bs.delete(op.Key)
+			} else {
+				err = bs.save(op.Key, op.Value)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (bs *BTreeStorager) CompareAndSwap(k, old, new []byte) (bool, error) {
+	swapped := false
+
+	err := bs.update(func() error {
+		cur, err := bs.load(k)
+		if errors.Is(err, ErrNotFound) {
+			cur, err = nil, nil
+		} else if err == nil && cur == nil {
+			cur = []byte{}
+		}
+
+		if err != nil || !casMatches(cur, old) {
+			return err
+		}
+
+		swapped = true
+		if new == nil {
+			return bs.delete(k)
+		}
+
+		return bs.save(k, new)
+	})
+
+	return swapped && err == nil, err
+}
+
+// commit publishes the new root. Pages released by this update only become
+// reusable once the meta page pointing away from them is durable.
+func (bs *BTreeStorager) commit() error {
+	if !bs.NoSync {
+		if err := bs.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if err := bs.writeMeta(bs.txid + 1); err != nil {
+		return err
+	}
+
+	if !bs.NoSync {
+		if err := bs.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	bs.txid++
+	bs.free = append(bs.free, bs.pending...)
+	bs.pending = nil
+	return nil
+}
+
+func (bs *BTreeStorager) value(v btreeValue) ([]byte, error) {
+	if !v.overflow {
+		return v.inline, nil
+	}
+
+	dt, err := bs.readPages(v.ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	return dt[:v.size], nil
+}
+
+func (bs *BTreeStorager) Load(k []byte) ([]byte, error) {
+	bs.lock.RLock()
+	defer bs.lock.RUnlock()
+
+	if bs.file == nil {
+		return nil, os.ErrClosed
+	}
+
+	return bs.load(k)
+}
+
+// load must be called with the lock held.
+func (bs *BTreeStorager) load(k []byte) ([]byte, error) {
+	ptr := bs.root
+	for ptr.pages != 0 {
+		n, err := bs.readNode(ptr)
+		if err != nil {
+			return nil, err
+		}
+
+		i := sort.Search(len(n.keys), func(i int) bool {
+			return bytes.Compare(n.keys[i], k) > 0
+		})
+
+		if n.leaf {
+			if i == 0 || !bytes.Equal(n.keys[i-1], k) {
+				break
+			}
+
+			return bs.value(n.values[i-1])
+		}
+
+		if i == 0 {
+			break
+		}
+
+		ptr = n.children[i-1]
+	}
+
+	return nil, ErrNotFound
+}
+
+// Range calls fn for every key in [start, end) in ascending order, until fn
+// returns false. A nil start or end leaves that side unbounded. fn must not
+// call Save on the same storager.
+func (bs *BTreeStorager) Range(start, end []byte, fn func(k, v []byte) bool) error {
+	bs.lock.RLock()
+	defer bs.lock.RUnlock()
+
+	if bs.file == nil {
+		return os.ErrClosed
+	}
+
+	_, err := bs.rangeNode(bs.root, start, end, fn)
+	return err
+}
+
+func (bs *BTreeStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	start, end := opts.bounds()
+
+	visited := 0
+	return bs.Range(start, end, func(k, v []byte) bool {
+		if opts.Limit > 0 && visited >= opts.Limit {
+			return false
+		}
+
+		visited++
+		if opts.KeysOnly {
+			v = nil
+		}
+
+		return fn(k, v)
+	})
+}
+
+func (bs *BTreeStorager) rangeNode(ptr btreePtr, start, end []byte, fn func(k, v []byte) bool) (bool, error) {
+	if ptr.pages == 0 {
+		return true, nil
+	}
+
+	n, err := bs.readNode(ptr)
+	if err != nil {
+		return false, err
+	}
+
+	for i, k := range n.keys {
+		if end != nil && bytes.Compare(k, end) >= 0 {
+			return false, nil
+		}
+
+		if n.leaf {
+			if start != nil && bytes.Compare(k, start) < 0 {
+				continue
+			}
+
+			v, err := bs.value(n.values[i])
+			if err != nil {
+				return false, err
+			}
+
+			if !fn(k, v) {
+				return false, nil
+			}
+			continue
+		}
+
+		if start != nil && i+1 < len(n.keys) && bytes.Compare(n.keys[i+1], start) <= 0 {
+			continue
+		}
+
+		next, err := bs.rangeNode(n.children[i], start, end, fn)
+		if !next || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (bs *BTreeStorager) Close() error {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	if bs.file == nil {
+		return os.ErrClosed
+	}
+
+	err := bs.file.Close()
+	bs.file = nil
+	return err
+}
@@ -0,0 +1,353 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestBTreeStorager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	storager, err := OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storager.NoSync = true
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	values := map[string][]byte{}
+	for _, i := range rand.New(rand.NewSource(1)).Perm(2000) {
+		k := []byte(fmt.Sprintf("%c/key%05d", PersistentStringPrefix, i))
+		v := bytes.Repeat([]byte{byte(i)}, i%7*100)
+		if i%500 == 0 {
+			v = bytes.Repeat([]byte{byte(i)}, 3*btreePageSize)
+		}
+
+		if err := storager.Save(k, v); err != nil {
+			t.Fatal(err)
+		}
+		values[string(k)] = v
+	}
+
+	// overwrite every tenth key
+	for i := 0; i < 2000; i += 10 {
+		k := []byte(fmt.Sprintf("%c/key%05d", PersistentStringPrefix, i))
+		if err := storager.Save(k, []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		values[string(k)] = []byte("new")
+	}
+
+	if err := storager.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storager, err = OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	for k, v := range values {
+		dt, err := storager.Load([]byte(k))
+		if err != nil {
+			t.Fatalf("%q: %v", k, err)
+		}
+
+		if !bytes.Equal(dt, v) {
+			t.Fatalf("%q: expected %d bytes, got %d", k, len(v), len(dt))
+		}
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestBTreeStoragerRange(t *testing.T) {
+	storager, err := OpenBTreeStorager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+	storager.NoSync = true
+
+	keys := []string{}
+	for _, i := range rand.New(rand.NewSource(2)).Perm(1000) {
+		k := fmt.Sprintf("user%d/Field%d", i%50, i)
+		keys = append(keys, k)
+		if err := storager.Save([]byte(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(keys)
+
+	got := []string{}
+	err = storager.Range(nil, nil, func(k, v []byte) bool {
+		got = append(got, string(k))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(keys) {
+		t.Fatal("keys are not iterated in order")
+	}
+
+	got = got[:0]
+	err = storager.Range([]byte("user7/"), []byte("user70"), func(k, v []byte) bool {
+		if !bytes.Equal(k, v) {
+			t.Fatalf("%q has value %q", k, v)
+		}
+		got = append(got, string(k))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 20 {
+		t.Fatalf("expected 20 fields of user7, got %d", len(got))
+	}
+
+	got = got[:0]
+	storager.Range([]byte("user1"), nil, func(k, v []byte) bool {
+		got = append(got, string(k))
+		return len(got) < 5
+	})
+
+	if len(got) != 5 || got[0] != keys[sort.SearchStrings(keys, "user1")] {
+		t.Fatalf("unexpected limited range %v", got)
+	}
+}
+
+func TestBTreeStoragerRootSwitch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	storager, err := OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storager.Save([]byte("a"), []byte("1"))
+	storager.Save([]byte("a"), []byte("2"))
+	txid := storager.txid
+	storager.Close()
+
+	// tear the meta page of the last Save
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte{0xff, 0xff}, int64(txid%2)*btreePageSize+14)
+	file.Close()
+
+	storager, err = OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	dt, err := storager.Load([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(dt) != "1" {
+		t.Fatalf("expected the previous root to be used, got %q", dt)
+	}
+}
+
+func TestBTreeStoragerStruct(t *testing.T) {
+	storager, err := OpenBTreeStorager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	type pessoa struct {
+		Nome  *PersistentString
+		Idade *PersistentUint32
+	}
+
+	pCarlos := &pessoa{
+		Nome:  NewPersistentString("Carlos"),
+		Idade: NewPersistentUint32(21),
+	}
+
+	PersistStruct("pcarlos", pCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	nCarlos := &pessoa{}
+	RestoreStruct("pcarlos", nCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *pCarlos.Nome != *nCarlos.Nome || *pCarlos.Idade != *nCarlos.Idade {
+		t.Fail()
+	}
+}
+
+func TestBTreeStoragerDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	storager, err := OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storager.NoSync = true
+
+	keys := map[string]bool{}
+	for _, i := range rand.New(rand.NewSource(3)).Perm(1000) {
+		k := fmt.Sprintf("key%04d", i)
+		v := bytes.Repeat([]byte{byte(i)}, i%5*300)
+		if err := storager.Save([]byte(k), v); err != nil {
+			t.Fatal(err)
+		}
+		keys[k] = true
+	}
+
+	pages := storager.pageCount
+
+	// delete everything but every tenth key, in random order
+	for _, i := range rand.New(rand.NewSource(4)).Perm(1000) {
+		if i%10 == 0 {
+			continue
+		}
+
+		k := fmt.Sprintf("key%04d", i)
+		if err := storager.Delete([]byte(k)); err != nil {
+			t.Fatal(err)
+		}
+		delete(keys, k)
+	}
+
+	if err := storager.Delete([]byte("missing")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storager.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storager, err = OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	got := 0
+	err = storager.Range(nil, nil, func(k, v []byte) bool {
+		if !keys[string(k)] {
+			t.Fatalf("%q was deleted", k)
+		}
+		got++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), got)
+	}
+
+	if _, err := storager.Load([]byte("key0001")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	for k := range keys {
+		if err := storager.Delete([]byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if storager.root.pages != 0 {
+		t.Fatal("expected the tree to be empty")
+	}
+
+	if err := storager.Save([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if storager.pageCount > pages {
+		t.Fatal("the pages of deleted keys were not reused")
+	}
+}
+
+func TestBTreeStoragerBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	storager, err := OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storager.Save([]byte("a"), []byte("1"))
+	txid := storager.txid
+
+	err = storager.Batch([]BatchOp{
+		{Key: []byte("a"), Delete: true},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: bytes.Repeat([]byte("3"), btreeMaxInline+1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if storager.txid != txid+1 {
+		t.Fatalf("expected a single commit, got %d", storager.txid-txid)
+	}
+
+	// a batch failing halfway leaves the tree as it was
+	file := storager.file
+	storager.file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storager.Batch([]BatchOp{
+		{Key: []byte("b"), Delete: true},
+		{Key: []byte("d"), Value: []byte("4")},
+	})
+	if err == nil {
+		t.Fatal("expected the batch to fail on a read only file")
+	}
+
+	storager.file.Close()
+	storager.file = file
+	storager.Close()
+
+	storager, err = OpenBTreeStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected b to survive the failed batch, got %q, %v", dt, err)
+	}
+
+	if _, err := storager.Load([]byte("d")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected d to be rolled back, got %v", err)
+	}
+}
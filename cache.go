@@ -0,0 +1,243 @@
+package persistent
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+type cacheEntry struct {
+	key   string
+	value []byte
+
+	// missing marks a negatively cached key.
+	missing bool
+}
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int
+}
+
+// CachedStorager wraps a Storager with a least recently used read cache
+// bounded by entries and bytes. Saves are written through to the wrapped
+// Storager before the cache is updated, and a Load running at the same time
+// as a write does not cache what it read. With NegativeCache set, keys that
+// were not found are cached too until they are saved.
+type CachedStorager struct {
+	Storager      Storager
+	MaxEntries    int
+	MaxBytes      int
+	NegativeCache bool
+
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	bytes   int
+	gen     uint64
+	stats   CacheStats
+}
+
+func NewCachedStorager(s Storager, maxEntries, maxBytes int) *CachedStorager {
+	return &CachedStorager{
+		Storager:   s,
+		MaxEntries: maxEntries,
+		MaxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+func (cs *CachedStorager) remove(el *list.Element) {
+	entry := cs.lru.Remove(el).(*cacheEntry)
+	delete(cs.entries, entry.key)
+	cs.bytes -= len(entry.key) + len(entry.value)
+}
+
+// put must be called with the lock held.
+func (cs *CachedStorager) put(k string, v []byte, missing bool) {
+	if el, ok := cs.entries[k]; ok {
+		cs.remove(el)
+	}
+
+	size := len(k) + len(v)
+	if cs.MaxBytes > 0 && size > cs.MaxBytes {
+		return
+	}
+
+	val := make([]byte, len(v))
+	copy(val, v)
+
+	cs.entries[k] = cs.lru.PushFront(&cacheEntry{key: k, value: val, missing: missing})
+	cs.bytes += size
+
+	for cs.MaxEntries > 0 && cs.lru.Len() > cs.MaxEntries ||
+		cs.MaxBytes > 0 && cs.bytes > cs.MaxBytes {
+
+		cs.remove(cs.lru.Back())
+		cs.stats.Evictions++
+	}
+}
+
+func (cs *CachedStorager) Save(k, v []byte) error {
+	return cs.SaveContext(context.Background(), k, v)
+}
+
+func (cs *CachedStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	cs.lock.Lock()
+	cs.gen++
+	gen := cs.gen
+	if el, ok := cs.entries[string(k)]; ok {
+		cs.remove(el)
+	}
+	cs.lock.Unlock()
+
+	if err := saveContext(ctx, cs.Storager, k, v); err != nil {
+		cs.Invalidate(k)
+		return err
+	}
+
+	cs.lock.Lock()
+	// with concurrent Saves the order they reached the Storager is unknown,
+	// and a Load that ran meanwhile may have read the old value, so it must
+	// not cache it
+	latest := gen == cs.gen
+	cs.gen++
+	if latest {
+		cs.put(string(k), v, false)
+	} else if el, ok := cs.entries[string(k)]; ok {
+		cs.remove(el)
+	}
+	cs.lock.Unlock()
+
+	return nil
+}
+
+func (cs *CachedStorager) Load(k []byte) ([]byte, error) {
+	return cs.LoadContext(context.Background(), k)
+}
+
+func (cs *CachedStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	cs.lock.Lock()
+	if el, ok := cs.entries[string(k)]; ok {
+		cs.lru.MoveToFront(el)
+		cs.stats.Hits++
+		entry := el.Value.(*cacheEntry)
+		cs.lock.Unlock()
+
+		if entry.missing {
+			return nil, ErrNotFound
+		}
+
+		dt := make([]byte, len(entry.value))
+		copy(dt, entry.value)
+		return dt, nil
+	}
+
+	cs.stats.Misses++
+	gen := cs.gen
+	cs.lock.Unlock()
+
+	dt, err := loadContext(ctx, cs.Storager, k)
+
+	missing := errors.Is(err, ErrNotFound)
+	if err != nil && !(missing && cs.NegativeCache) {
+		return nil, err
+	}
+
+	cs.lock.Lock()
+	// a Save that ran meanwhile may have made dt stale
+	if gen == cs.gen {
+		cs.put(string(k), dt, missing)
+	}
+	cs.lock.Unlock()
+
+	return dt, err
+}
+
+func (cs *CachedStorager) wrapped() []Storager {
+	return []Storager{cs.Storager}
+}
+
+func (cs *CachedStorager) Delete(k []byte) error {
+	return cs.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext drops k from the cache, before and after the delete as Batch
+// does.
+func (cs *CachedStorager) DeleteContext(ctx context.Context, k []byte) error {
+	cs.Invalidate(k)
+	err := DeleteContext(ctx, cs.Storager, k)
+	cs.Invalidate(k)
+
+	return err
+}
+
+// Scan goes straight to the wrapped Storager and leaves the cache alone.
+func (cs *CachedStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return cs.ScanContext(context.Background(), opts, fn)
+}
+
+func (cs *CachedStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	return ScanContext(ctx, cs.Storager, opts, fn)
+}
+
+// Invalidate drops k from the cache, for writes made to the wrapped Storager
+// behind the cache's back.
+func (cs *CachedStorager) Invalidate(k []byte) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.gen++
+	if el, ok := cs.entries[string(k)]; ok {
+		cs.remove(el)
+	}
+}
+
+func (cs *CachedStorager) Stats() CacheStats {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	stats := cs.stats
+	stats.Entries = cs.lru.Len()
+	stats.Bytes = cs.bytes
+	return stats
+}
+
+// Batch drops the keys of ops from the cache, before and after the batch so
+// a Load running meanwhile cannot cache a stale value.
+func (cs *CachedStorager) Batch(ops []BatchOp) error {
+	return cs.BatchContext(context.Background(), ops)
+}
+
+func (cs *CachedStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	for _, op := range ops {
+		cs.Invalidate(op.Key)
+	}
+
+	err := BatchContext(ctx, cs.Storager, ops)
+
+	for _, op := range ops {
+		cs.Invalidate(op.Key)
+	}
+
+	return err
+}
+
+// CompareAndSwap drops k from the cache, before and after the swap as Batch
+// does.
+func (cs *CachedStorager) CompareAndSwap(k, old, new []byte) (bool, error) {
+	return cs.CompareAndSwapContext(context.Background(), k, old, new)
+}
+
+func (cs *CachedStorager) CompareAndSwapContext(ctx context.Context, k, old, new []byte) (bool, error) {
+	cs.Invalidate(k)
+	swapped, err := CompareAndSwapContext(ctx, cs.Storager, k, old, new)
+	cs.Invalidate(k)
+
+	return swapped, err
+}
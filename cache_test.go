@@ -0,0 +1,238 @@
+package persistent
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+type countingStorager struct {
+	Storager
+	saves int64
+	loads int64
+}
+
+func (cs *countingStorager) Save(k, v []byte) error {
+	atomic.AddInt64(&cs.saves, 1)
+	return cs.Storager.Save(k, v)
+}
+
+func (cs *countingStorager) Load(k []byte) ([]byte, error) {
+	atomic.AddInt64(&cs.loads, 1)
+	return cs.Storager.Load(k)
+}
+
+// blockingStorager blocks its Saves and Deletes, and its Loads after
+// reading, handing out a channel to close to let each call go on.
+type blockingStorager struct {
+	Storager
+	blocked chan chan struct{}
+}
+
+func (bs *blockingStorager) block() {
+	release := make(chan struct{})
+	bs.blocked <- release
+	<-release
+}
+
+func (bs *blockingStorager) Save(k, v []byte) error {
+	bs.block()
+	return bs.Storager.Save(k, v)
+}
+
+func (bs *blockingStorager) Load(k []byte) ([]byte, error) {
+	dt, err := bs.Storager.Load(k)
+	bs.block()
+	return dt, err
+}
+
+func (bs *blockingStorager) Delete(k []byte) error {
+	bs.block()
+	return Delete(bs.Storager, k)
+}
+
+// staleLoad runs a Load of k that reads "old" from the backend, and returns
+// it only after write went through.
+func staleLoad(t *testing.T, write func(s *CachedStorager) error) *CachedStorager {
+	memory := NewMemoryStorager()
+	memory.Save([]byte("k"), []byte("old"))
+
+	backend := &blockingStorager{Storager: memory, blocked: make(chan chan struct{})}
+	storager := NewCachedStorager(backend, 10, 0)
+
+	written := make(chan error)
+	go func() { written <- write(storager) }()
+	releaseWrite := <-backend.blocked
+
+	loaded := make(chan error)
+	go func() {
+		_, err := storager.Load([]byte("k"))
+		loaded <- err
+	}()
+	releaseLoad := <-backend.blocked
+
+	close(releaseWrite)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	close(releaseLoad)
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+
+	// later calls go straight through
+	go func() {
+		for release := range backend.blocked {
+			close(release)
+		}
+	}()
+	t.Cleanup(func() { close(backend.blocked) })
+
+	return storager
+}
+
+func TestCachedStoragerStaleSave(t *testing.T) {
+	storager := staleLoad(t, func(s *CachedStorager) error {
+		return s.Save([]byte("k"), []byte("new"))
+	})
+
+	if dt, err := storager.Load([]byte("k")); err != nil || string(dt) != "new" {
+		t.Fatalf("expected the saved value, got %q, %v", dt, err)
+	}
+}
+
+func TestCachedStoragerStaleDelete(t *testing.T) {
+	storager := staleLoad(t, func(s *CachedStorager) error {
+		return s.Delete([]byte("k"))
+	})
+
+	if dt, err := storager.Load([]byte("k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the key to be deleted, got %q, %v", dt, err)
+	}
+}
+
+func TestCachedStorager(t *testing.T) {
+	backend := &countingStorager{Storager: NewMemoryStorager()}
+	storager := NewCachedStorager(backend, 2, 0)
+
+	storager.Save([]byte("a"), []byte("1"))
+	storager.Save([]byte("b"), []byte("2"))
+
+	if backend.saves != 2 {
+		t.Fatal("saves were not written through")
+	}
+
+	for i := 0; i < 3; i++ {
+		if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "1" {
+			t.Fatalf("expected 1, got %q, %v", dt, err)
+		}
+	}
+
+	if backend.loads != 0 {
+		t.Fatalf("expected cache hits, got %d backend loads", backend.loads)
+	}
+
+	// evicts b, the least recently used
+	storager.Save([]byte("c"), []byte("3"))
+
+	if _, err := storager.Load([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := storager.Stats()
+	if backend.loads != 1 || stats.Hits != 3 || stats.Misses != 1 || stats.Evictions != 2 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats %+v, %d backend loads", stats, backend.loads)
+	}
+
+	dt, _ := storager.Load([]byte("b"))
+	dt[0] = 'X'
+	if dt, _ := storager.Load([]byte("b")); string(dt) != "2" {
+		t.Fatal("cached value shares memory with the caller")
+	}
+}
+
+func TestCachedStoragerBytes(t *testing.T) {
+	storager := NewCachedStorager(NewMemoryStorager(), 0, 100)
+
+	for i := 0; i < 10; i++ {
+		storager.Save([]byte(fmt.Sprint(i)), make([]byte, 30))
+	}
+
+	if stats := storager.Stats(); stats.Bytes > 100 || stats.Entries != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	storager.Save([]byte("big"), make([]byte, 200))
+	if dt, err := storager.Load([]byte("big")); err != nil || len(dt) != 200 {
+		t.Fatalf("expected the value larger than the cache, got %d bytes, %v", len(dt), err)
+	}
+}
+
+func TestCachedStoragerNegative(t *testing.T) {
+	backend := &countingStorager{Storager: NewMemoryStorager()}
+	storager := NewCachedStorager(backend, 10, 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+
+	if backend.loads != 2 {
+		t.Fatal("misses must not be cached by default")
+	}
+
+	storager.NegativeCache = true
+	for i := 0; i < 2; i++ {
+		if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+
+	if backend.loads != 3 {
+		t.Fatalf("expected the miss to be cached, got %d backend loads", backend.loads)
+	}
+
+	storager.Save([]byte("a"), []byte("1"))
+	if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "1" {
+		t.Fatalf("expected 1, got %q, %v", dt, err)
+	}
+}
+
+func TestCachedStoragerStruct(t *testing.T) {
+	backend := &countingStorager{Storager: NewMemoryStorager()}
+	storager := NewCachedStorager(backend, 100, 0)
+
+	type pessoa struct {
+		Nome  *PersistentString
+		Idade *PersistentUint32
+	}
+
+	PersistStruct("pcarlos", &pessoa{
+		Nome:  NewPersistentString("Carlos"),
+		Idade: NewPersistentUint32(21),
+	}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	for i := 0; i < 5; i++ {
+		nCarlos := &pessoa{}
+		RestoreStruct("pcarlos", nCarlos, storager, func(e error) {
+			if e != nil {
+				t.Fatal(e)
+			}
+		})
+
+		if *nCarlos.Nome != "Carlos" || *nCarlos.Idade != 21 {
+			t.Fail()
+		}
+	}
+
+	if backend.loads != 0 {
+		t.Fatalf("expected every field to be cached, got %d backend loads", backend.loads)
+	}
+}
@@ -0,0 +1,114 @@
+package persistent
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptionError reports a value that failed its integrity check.
+type CorruptionError struct {
+	Key    []byte
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("value of %q is corrupted: %s", e.Key, e.Reason)
+}
+
+// ChecksumStorager appends the CRC-32C of the key and value to every saved
+// value and verifies it on Load, returning a *CorruptionError for truncated
+// or altered values instead of handing them to the decoders.
+type ChecksumStorager struct {
+	Storager Storager
+}
+
+func NewChecksumStorager(s Storager) *ChecksumStorager {
+	return &ChecksumStorager{Storager: s}
+}
+
+func checksum(k, v []byte) uint32 {
+	crc := crc32.Update(0, castagnoli, k)
+	return crc32.Update(crc, castagnoli, v)
+}
+
+func (cs *ChecksumStorager) Save(k, v []byte) error {
+	return cs.SaveContext(context.Background(), k, v)
+}
+
+func (cs *ChecksumStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	return saveContext(ctx, cs.Storager, k, appendChecksum(k, v))
+}
+
+func appendChecksum(k, v []byte) []byte {
+	dt := make([]byte, len(v)+4)
+	copy(dt, v)
+	binary.LittleEndian.PutUint32(dt[len(v):], checksum(k, v))
+	return dt
+}
+
+func (cs *ChecksumStorager) Load(k []byte) ([]byte, error) {
+	return cs.LoadContext(context.Background(), k)
+}
+
+func (cs *ChecksumStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	dt, err := loadContext(ctx, cs.Storager, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyChecksum(k, dt)
+}
+
+func verifyChecksum(k, dt []byte) ([]byte, error) {
+	if len(dt) < 4 {
+		return nil, &CorruptionError{Key: k, Reason: "value is shorter than its checksum"}
+	}
+
+	v := dt[:len(dt)-4]
+	if checksum(k, v) != binary.LittleEndian.Uint32(dt[len(v):]) {
+		return nil, &CorruptionError{Key: k, Reason: "checksum mismatch"}
+	}
+
+	return v, nil
+}
+
+func (cs *ChecksumStorager) wrapped() []Storager {
+	return []Storager{cs.Storager}
+}
+
+func (cs *ChecksumStorager) Delete(k []byte) error {
+	return cs.DeleteContext(context.Background(), k)
+}
+
+func (cs *ChecksumStorager) DeleteContext(ctx context.Context, k []byte) error {
+	return DeleteContext(ctx, cs.Storager, k)
+}
+
+// Scan verifies every value visited, stopping at the first corrupted one.
+func (cs *ChecksumStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return cs.ScanContext(context.Background(), opts, fn)
+}
+
+func (cs *ChecksumStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	return scanValues(ctx, cs.Storager, opts, verifyChecksum, fn)
+}
+
+func (cs *ChecksumStorager) Batch(ops []BatchOp) error {
+	return cs.BatchContext(context.Background(), ops)
+}
+
+func (cs *ChecksumStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	checked, _ := mapBatch(ops, func(op BatchOp) (BatchOp, error) {
+		if !op.Delete {
+			op.Value = appendChecksum(op.Key, op.Value)
+		}
+
+		return op, nil
+	})
+
+	return BatchContext(ctx, cs.Storager, checked)
+}
@@ -0,0 +1,58 @@
+package persistent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChecksumStorager(t *testing.T) {
+	backend := NewMemoryStorager()
+	storager := NewChecksumStorager(backend)
+
+	testBool := NewPersistentBool(true)
+	if err := testBool.Persist(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	nTest := EmptyPersistentBool()
+	if err := nTest.Restore(storager, []byte("test")); err != nil || !*nTest {
+		t.Fatalf("expected true, got %v, %v", *nTest, err)
+	}
+
+	key := []byte{PersistentBoolPrefix, 't', 'e', 's', 't'}
+	raw, _ := backend.Load(key)
+
+	var corruption *CorruptionError
+
+	backend.Save(key, raw[:2])
+	if err := nTest.Restore(storager, []byte("test")); !errors.As(err, &corruption) {
+		t.Fatalf("expected a corruption error, got %v", err)
+	}
+
+	if string(corruption.Key) != string(key) {
+		t.Fatalf("unexpected key %q", corruption.Key)
+	}
+
+	backend.Save(key, []byte{})
+	if err := nTest.Restore(storager, []byte("test")); !errors.As(err, &corruption) {
+		t.Fatalf("expected a corruption error, got %v", err)
+	}
+
+	raw[0] ^= 1
+	backend.Save(key, raw)
+	if err := nTest.Restore(storager, []byte("test")); !errors.As(err, &corruption) {
+		t.Fatalf("expected a corruption error, got %v", err)
+	}
+
+	// values cannot be moved to another key
+	testBool.Persist(storager, []byte("other"))
+	raw, _ = backend.Load([]byte{PersistentBoolPrefix, 'o', 't', 'h', 'e', 'r'})
+	backend.Save(key, raw)
+	if err := nTest.Restore(storager, []byte("test")); !errors.As(err, &corruption) {
+		t.Fatalf("expected a corruption error, got %v", err)
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package persistent
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	RawCompression = byte(iota)
+	FlateCompression
+	GzipCompression
+)
+
+// ErrValueTooLarge is returned by CompressedStorager for values over its
+// MaxSize, before compressing them or once decompressing them went past it.
+var ErrValueTooLarge = errors.New("value is over the size limit")
+
+// DefaultMaxValueSize is the MaxSize of a CompressedStorager without one.
+const DefaultMaxValueSize = 64 << 20
+
+// Compressor is a compression algorithm usable by CompressedStorager. ID is
+// written in the header of every value it compressed.
+type Compressor interface {
+	ID() byte
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// LimitedDecompressor is a Compressor able to stop decompressing once the
+// value goes past max bytes, returning ErrValueTooLarge. CompressedStorager
+// checks the size of the values of other Compressors after decompressing
+// them.
+type LimitedDecompressor interface {
+	DecompressLimit(dt []byte, max int64) ([]byte, error)
+}
+
+// readLimited reads r up to max bytes, any amount when max is negative.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	if max < 0 {
+		return io.ReadAll(r)
+	}
+
+	v, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err == nil && int64(len(v)) > max {
+		return nil, ErrValueTooLarge
+	}
+
+	return v, err
+}
+
+type FlateCompressor struct {
+	Level int
+}
+
+func (c FlateCompressor) ID() byte {
+	return FlateCompression
+}
+
+func (c FlateCompressor) Compress(v []byte) ([]byte, error) {
+	buff := new(bytes.Buffer)
+	w, err := flate.NewWriter(buff, c.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(v); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (c FlateCompressor) Decompress(dt []byte) ([]byte, error) {
+	return c.DecompressLimit(dt, -1)
+}
+
+func (c FlateCompressor) DecompressLimit(dt []byte, max int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(dt))
+	defer r.Close()
+
+	return readLimited(r, max)
+}
+
+type GzipCompressor struct {
+	Level int
+}
+
+func (c GzipCompressor) ID() byte {
+	return GzipCompression
+}
+
+func (c GzipCompressor) Compress(v []byte) ([]byte, error) {
+	buff := new(bytes.Buffer)
+	w, err := gzip.NewWriterLevel(buff, c.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(v); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(dt []byte) ([]byte, error) {
+	return c.DecompressLimit(dt, -1)
+}
+
+func (c GzipCompressor) DecompressLimit(dt []byte, max int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(dt))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return readLimited(r, max)
+}
+
+// CompressedStorager compresses values of at least Threshold bytes with
+// Compressor before handing them to the wrapped Storager. Every value starts
+// with a byte naming the algorithm that compressed it, RawCompression for
+// values that were left alone, so Load decompresses with whichever
+// registered Compressor was used at the time.
+type CompressedStorager struct {
+	Storager   Storager
+	Compressor Compressor
+	Threshold  int
+
+	// MaxSize bounds the values saved and decompressed, in bytes, so a
+	// small stored value cannot expand without limit when loaded.
+	// DefaultMaxValueSize is used when it is not above zero.
+	MaxSize int64
+
+	compressors map[byte]Compressor
+}
+
+func NewCompressedStorager(s Storager, threshold int) *CompressedStorager {
+	cs := &CompressedStorager{
+		Storager:    s,
+		Compressor:  FlateCompressor{Level: flate.DefaultCompression},
+		Threshold:   threshold,
+		MaxSize:     DefaultMaxValueSize,
+		compressors: map[byte]Compressor{},
+	}
+
+	cs.Register(FlateCompressor{Level: flate.DefaultCompression})
+	cs.Register(GzipCompressor{Level: gzip.DefaultCompression})
+	return cs
+}
+
+// Register makes a Compressor available to Load. It is not safe to call
+// concurrently with Load.
+func (cs *CompressedStorager) Register(c Compressor) {
+	cs.compressors[c.ID()] = c
+}
+
+func (cs *CompressedStorager) Save(k, v []byte) error {
+	return cs.SaveContext(context.Background(), k, v)
+}
+
+func (cs *CompressedStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	dt, err := cs.compress(v)
+	if err != nil {
+		return err
+	}
+
+	return saveContext(ctx, cs.Storager, k, dt)
+}
+
+func (cs *CompressedStorager) maxSize() int64 {
+	if cs.MaxSize <= 0 {
+		return DefaultMaxValueSize
+	}
+
+	return cs.MaxSize
+}
+
+func (cs *CompressedStorager) compress(v []byte) ([]byte, error) {
+	// a value Load would refuse is not saved
+	if int64(len(v)) > cs.maxSize() {
+		return nil, ErrValueTooLarge
+	}
+
+	if len(v) >= cs.Threshold && cs.Compressor != nil {
+		dt, err := cs.Compressor.Compress(v)
+		if err != nil {
+			return nil, err
+		}
+
+		// incompressible values are kept raw
+		if len(dt) < len(v) {
+			return append([]byte{cs.Compressor.ID()}, dt...), nil
+		}
+	}
+
+	return append([]byte{RawCompression}, v...), nil
+}
+
+func (cs *CompressedStorager) Load(k []byte) ([]byte, error) {
+	return cs.LoadContext(context.Background(), k)
+}
+
+func (cs *CompressedStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	dt, err := loadContext(ctx, cs.Storager, k)
+	if err != nil {
+		return nil, err
+	}
+
+	return cs.decompress(k, dt)
+}
+
+func (cs *CompressedStorager) decompress(k, dt []byte) ([]byte, error) {
+	if len(dt) == 0 {
+		return nil, fmt.Errorf("%q has no compression header", k)
+	}
+
+	if dt[0] == RawCompression {
+		return dt[1:], nil
+	}
+
+	c, ok := cs.compressors[dt[0]]
+	if !ok {
+		return nil, fmt.Errorf("%q is compressed with unknown algorithm %d", k, dt[0])
+	}
+
+	var v []byte
+	var err error
+	if ld, ok := c.(LimitedDecompressor); ok {
+		v, err = ld.DecompressLimit(dt[1:], cs.maxSize())
+	} else if v, err = c.Decompress(dt[1:]); err == nil && int64(len(v)) > cs.maxSize() {
+		err = ErrValueTooLarge
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %q: %w", k, err)
+	}
+
+	return v, nil
+}
+
+func (cs *CompressedStorager) wrapped() []Storager {
+	return []Storager{cs.Storager}
+}
+
+func (cs *CompressedStorager) Delete(k []byte) error {
+	return cs.DeleteContext(context.Background(), k)
+}
+
+func (cs *CompressedStorager) DeleteContext(ctx context.Context, k []byte) error {
+	return DeleteContext(ctx, cs.Storager, k)
+}
+
+func (cs *CompressedStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return cs.ScanContext(context.Background(), opts, fn)
+}
+
+func (cs *CompressedStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	return scanValues(ctx, cs.Storager, opts, cs.decompress, fn)
+}
+
+func (cs *CompressedStorager) Batch(ops []BatchOp) error {
+	return cs.BatchContext(context.Background(), ops)
+}
+
+func (cs *CompressedStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	compressed, err := mapBatch(ops, func(op BatchOp) (BatchOp, error) {
+		var err error
+		if !op.Delete {
+			op.Value, err = cs.compress(op.Value)
+		}
+
+		return op, err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return BatchContext(ctx, cs.Storager, compressed)
+}
@@ -0,0 +1,111 @@
+package persistent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompressedStorager(t *testing.T) {
+	backend := NewMemoryStorager()
+	storager := NewCompressedStorager(backend, 64)
+
+	values := map[string][]byte{
+		"small":  []byte("small"),
+		"large":  bytes.Repeat([]byte("Olá mundo! "), 1000),
+		"random": []byte(strings.Repeat("\x00\xff", 10)),
+		"empty":  {},
+	}
+
+	for k, v := range values {
+		if err := storager.Save([]byte(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, _ := backend.Load([]byte("small"))
+	if raw[0] != RawCompression {
+		t.Fatal("small value was compressed")
+	}
+
+	raw, _ = backend.Load([]byte("large"))
+	if raw[0] != FlateCompression || len(raw) > len(values["large"])/10 {
+		t.Fatalf("large value was not compressed: %d bytes", len(raw))
+	}
+
+	// values keep being readable after switching algorithm
+	storager.Compressor = GzipCompressor{Level: gzip.BestCompression}
+	storager.Save([]byte("gzip"), values["large"])
+	values["gzip"] = values["large"]
+
+	for k, v := range values {
+		dt, err := storager.Load([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(dt, v) {
+			t.Fatalf("%s: loaded value differs from the saved one", k)
+		}
+	}
+
+	backend.Save([]byte("unknown"), []byte{99, 1, 2})
+	if _, err := storager.Load([]byte("unknown")); err == nil {
+		t.Fatal("expected an unknown algorithm error")
+	}
+}
+
+func TestCompressedStoragerMaxSize(t *testing.T) {
+	backend := NewMemoryStorager()
+	writer := NewCompressedStorager(backend, 64)
+
+	zeros := make([]byte, 1<<20)
+	writer.Save([]byte("flate"), zeros)
+	writer.Compressor = GzipCompressor{Level: gzip.BestCompression}
+	writer.Save([]byte("gzip"), zeros)
+
+	storager := NewCompressedStorager(backend, 64)
+	storager.MaxSize = 1024
+
+	for _, k := range []string{"flate", "gzip"} {
+		if _, err := storager.Load([]byte(k)); !errors.Is(err, ErrValueTooLarge) {
+			t.Fatalf("%s: expected ErrValueTooLarge, got %v", k, err)
+		}
+	}
+
+	if err := storager.Save([]byte("large"), zeros); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+
+	if err := storager.Save([]byte("fits"), zeros[:1024]); err != nil {
+		t.Fatal(err)
+	}
+
+	if dt, err := storager.Load([]byte("fits")); err != nil || len(dt) != 1024 {
+		t.Fatalf("expected the value at the limit to load, got %d bytes, %v", len(dt), err)
+	}
+}
+
+func TestCompressedStoragerSlice(t *testing.T) {
+	storager := NewCompressedStorager(NewMemoryStorager(), 16)
+
+	testSlice := PersistentSlice([]Persistent{
+		NewPersistentString(strings.Repeat("a", 1000)),
+		NewPersistentString("b"),
+	})
+
+	if err := testSlice.Persist(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	nTest := PersistentSlice([]Persistent{})
+	if err := nTest.Restore(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*nTest[0].(*PersistentString)) != 1000 || *nTest[1].(*PersistentString) != "b" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,115 @@
+package persistent_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/carlosmpv/persistent"
+	"github.com/carlosmpv/persistent/persistenttest"
+)
+
+func TestConformance(t *testing.T) {
+	factories := map[string]func(t *testing.T) persistent.Storager{
+		"Memory": func(t *testing.T) persistent.Storager {
+			return persistent.NewMemoryStorager()
+		},
+		"File": func(t *testing.T) persistent.Storager {
+			s, err := persistent.NewFileStorager(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"Log": func(t *testing.T) persistent.Storager {
+			s, err := persistent.OpenLogStorager(filepath.Join(t.TempDir(), "test.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"BTree": func(t *testing.T) persistent.Storager {
+			s, err := persistent.OpenBTreeStorager(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.NoSync = true
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"HTTP": func(t *testing.T) persistent.Storager {
+			srv := httptest.NewServer(persistent.NewStoragerHandler(persistent.NewMemoryStorager()))
+			t.Cleanup(srv.Close)
+			return persistent.NewHTTPStorager(srv.URL)
+		},
+		"SQL": func(t *testing.T) persistent.Storager {
+			s, err := persistent.NewSQLStorager(persistent.OpenSQLite(t), "conformance", persistent.SQLiteDialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"Redis": func(t *testing.T) persistent.Storager {
+			s := persistent.NewRedisStorager(persistent.StartRedisFake(t), 4)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"S3": func(t *testing.T) persistent.Storager {
+			return persistent.NewS3Fake(t)
+		},
+		"Cached": func(t *testing.T) persistent.Storager {
+			s := persistent.NewCachedStorager(persistent.NewMemoryStorager(), 100, 1<<20)
+			s.NegativeCache = true
+			return s
+		},
+		"Sharded": func(t *testing.T) persistent.Storager {
+			return persistent.NewShardedStorager(map[string]persistent.Storager{
+				"a": persistent.NewMemoryStorager(),
+				"b": persistent.NewMemoryStorager(),
+			}, 16)
+		},
+		"Mirrored": func(t *testing.T) persistent.Storager {
+			return persistent.NewMirroredStorager(2, 2,
+				persistent.NewMemoryStorager(),
+				persistent.NewMemoryStorager(),
+				persistent.NewMemoryStorager(),
+			)
+		},
+		"Encrypted": func(t *testing.T) persistent.Storager {
+			keys := persistent.NewStaticKeyProvider(1, bytes.Repeat([]byte{1}, 32))
+			s := persistent.NewEncryptedStorager(persistent.NewMemoryStorager(), keys)
+			s.KeyMAC = []byte("mac")
+			return s
+		},
+		"Compressed": func(t *testing.T) persistent.Storager {
+			return persistent.NewCompressedStorager(persistent.NewMemoryStorager(), 32)
+		},
+		"Namespaced": func(t *testing.T) persistent.Storager {
+			s, err := persistent.NewNamespacedStorager(persistent.NewMemoryStorager(), "tenant")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"Checksum": func(t *testing.T) persistent.Storager {
+			return persistent.NewChecksumStorager(persistent.NewMemoryStorager())
+		},
+		"Retrying": func(t *testing.T) persistent.Storager {
+			return persistent.NewRetryingStorager(persistent.NewMemoryStorager())
+		},
+		"Instrumented": func(t *testing.T) persistent.Storager {
+			return persistent.NewInstrumentedStorager(persistent.NewMemoryStorager())
+		},
+		"Faulty": func(t *testing.T) persistent.Storager {
+			return persistent.NewFaultyStorager(persistent.NewMemoryStorager())
+		},
+	}
+
+	for name, factory := range factories {
+		t.Run(name, func(t *testing.T) {
+			persistenttest.RunStoragerSuite(t, factory)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package persistent
+
+import "context"
+
+// ContextStorager is a Storager whose calls honor the cancellation and
+// deadline of a context.
+type ContextStorager interface {
+	SaveContext(ctx context.Context, k, v []byte) error
+	LoadContext(ctx context.Context, k []byte) ([]byte, error)
+}
+
+// ContextPersistent is a Persistent whose Persist and Restore take a context,
+// passed on to the Storager.
+type ContextPersistent interface {
+	PersistContext(context.Context, Storager, []byte) error
+	RestoreContext(context.Context, Storager, []byte) error
+}
+
+// WithContext returns s when it is a ContextStorager, and otherwise adapts
+// it: calls fail as soon as ctx is done, without waiting for the underlying
+// Save or Load, which keeps running in the background and may still land.
+func WithContext(s Storager) ContextStorager {
+	if cs, ok := s.(ContextStorager); ok {
+		return cs
+	}
+
+	return legacyStorager{s}
+}
+
+type legacyStorager struct {
+	Storager
+}
+
+// runContext runs fn, returning early once ctx is done and leaving fn to
+// finish in the background.
+func runContext(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ls legacyStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	return runContext(ctx, func() error {
+		return ls.Save(k, v)
+	})
+}
+
+func (ls legacyStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	var dt []byte
+
+	err := runContext(ctx, func() error {
+		var err error
+		dt, err = ls.Load(k)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return dt, nil
+}
+
+func saveContext(ctx context.Context, s Storager, k, v []byte) error {
+	return WithContext(s).SaveContext(ctx, k, v)
+}
+
+func loadContext(ctx context.Context, s Storager, k []byte) ([]byte, error) {
+	return WithContext(s).LoadContext(ctx, k)
+}
+
+// PersistContext persists p with its PersistContext method, or with Persist
+// once ctx is checked when p does not take a context.
+func PersistContext(ctx context.Context, p Persistent, s Storager, k []byte) error {
+	if cp, ok := p.(ContextPersistent); ok {
+		return cp.PersistContext(ctx, s, k)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return p.Persist(s, k)
+}
+
+// RestoreContext restores p with its RestoreContext method, or with Restore
+// once ctx is checked when p does not take a context.
+func RestoreContext(ctx context.Context, p Persistent, s Storager, k []byte) error {
+	if cp, ok := p.(ContextPersistent); ok {
+		return cp.RestoreContext(ctx, s, k)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return p.Restore(s, k)
+}
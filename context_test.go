@@ -0,0 +1,191 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// hungStorager blocks every call until release is closed.
+type hungStorager struct {
+	release chan struct{}
+}
+
+func (hs *hungStorager) Save(k, v []byte) error {
+	<-hs.release
+	return nil
+}
+
+func (hs *hungStorager) Load(k []byte) ([]byte, error) {
+	<-hs.release
+	return nil, ErrNotFound
+}
+
+func TestWithContext(t *testing.T) {
+	s := &hungStorager{release: make(chan struct{})}
+	defer close(s.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := WithContext(s).SaveContext(ctx, []byte("a"), []byte("b")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if _, err := WithContext(s).LoadContext(ctx, []byte("a")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	m := NewMemoryStorager()
+	if cs := WithContext(m); cs.SaveContext(context.Background(), []byte("a"), []byte("b")) != nil {
+		t.Fatal("unexpected error")
+	}
+
+	if hs := NewHTTPStorager("http://localhost"); WithContext(hs) != ContextStorager(hs) {
+		t.Fatal("a ContextStorager was adapted")
+	}
+}
+
+func TestPersistStructContext(t *testing.T) {
+	s := &hungStorager{release: make(chan struct{})}
+	defer close(s.release)
+
+	type pessoa struct {
+		Nome  *PersistentString
+		Idade *PersistentUint32
+		Notas *PersistentSlice
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	errs := []error{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		PersistStructContext(ctx, "pcarlos", &pessoa{
+			Nome:  NewPersistentString("Carlos"),
+			Idade: NewPersistentUint32(21),
+			Notas: &PersistentSlice{NewPersistentFloat32(9.5)},
+		}, s, func(err error) {
+			errs = append(errs, err)
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("PersistStructContext ignored the deadline")
+	}
+
+	if len(errs) != 2 || !errors.Is(errs[0], context.DeadlineExceeded) || !errors.Is(errs[1], context.DeadlineExceeded) {
+		t.Fatalf("expected the first field to time out and the next ones to be skipped, got %v", errs)
+	}
+}
+
+func TestSliceRestoreContext(t *testing.T) {
+	m := NewMemoryStorager()
+	slc := PersistentSlice{NewPersistentInt8(1), NewPersistentInt8(2)}
+	if err := slc.Persist(m, []byte("slc")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	restored := PersistentSlice{}
+	if err := restored.RestoreContext(ctx, m, []byte("slc")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled restore, got %v", err)
+	}
+
+	if err := restored.RestoreContext(context.Background(), m, []byte("slc")); err != nil || len(restored) != 2 {
+		t.Fatalf("unexpected restore %v, %v", restored, err)
+	}
+}
+
+func TestWrapperContext(t *testing.T) {
+	// a server answering once the request is cancelled; the body has to be
+	// read for the server to notice the client going away
+	cancelled := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+
+		select {
+		case cancelled <- struct{}{}:
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	wrappers := map[string]func(Storager) Storager{
+		"Cached": func(s Storager) Storager {
+			return NewCachedStorager(s, 10, 1<<10)
+		},
+		"Encrypted": func(s Storager) Storager {
+			return NewEncryptedStorager(s, NewStaticKeyProvider(1, bytes.Repeat([]byte{1}, 32)))
+		},
+		"Compressed": func(s Storager) Storager {
+			return NewCompressedStorager(s, 32)
+		},
+		"Namespaced": func(s Storager) Storager {
+			ns, _ := NewNamespacedStorager(s, "tenant")
+			return ns
+		},
+		"Checksum": func(s Storager) Storager {
+			return NewChecksumStorager(s)
+		},
+		"Sharded": func(s Storager) Storager {
+			return NewShardedStorager(map[string]Storager{"a": s}, 16)
+		},
+		"Mirrored": func(s Storager) Storager {
+			return NewMirroredStorager(1, 1, s)
+		},
+		"Retrying": func(s Storager) Storager {
+			return NewRetryingStorager(s)
+		},
+		"Instrumented": func(s Storager) Storager {
+			return NewInstrumentedStorager(s)
+		},
+		"Faulty": func(s Storager) Storager {
+			return NewFaultyStorager(s)
+		},
+	}
+
+	for name, wrap := range wrappers {
+		t.Run(name, func(t *testing.T) {
+			s := WithContext(wrap(NewHTTPStorager(srv.URL)))
+
+			calls := map[string]func(context.Context) error{
+				"Save": func(ctx context.Context) error {
+					return s.SaveContext(ctx, []byte("a"), []byte("b"))
+				},
+				"Load": func(ctx context.Context) error {
+					_, err := s.LoadContext(ctx, []byte("a"))
+					return err
+				},
+			}
+
+			for op, call := range calls {
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				err := call(ctx)
+				cancel()
+
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("%s: expected the deadline to be exceeded, got %v", op, err)
+				}
+
+				select {
+				case <-cancelled:
+				case <-time.After(time.Second):
+					t.Fatalf("%s: the deadline did not reach the HTTP request", op)
+				}
+			}
+		})
+	}
+}
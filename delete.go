@@ -0,0 +1,109 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrDeleteUnsupported is returned when deleting through a Storager that does
+// not implement Deleter.
+var ErrDeleteUnsupported = errors.New("storager does not support Delete")
+
+// Deleter is implemented by the Storagers able to remove a key. Deleting a
+// missing key is not an error. Wrappers implement it by deleting through the
+// wrapped Storager, returning ErrDeleteUnsupported when it cannot.
+type Deleter interface {
+	Delete([]byte) error
+}
+
+// ContextDeleter is a Deleter whose calls honor the cancellation and deadline
+// of a context.
+type ContextDeleter interface {
+	DeleteContext(ctx context.Context, k []byte) error
+}
+
+// Delete removes k from s.
+func Delete(s Storager, k []byte) error {
+	return DeleteContext(context.Background(), s, k)
+}
+
+// DeleteContext removes k from s, adapting a Deleter that takes no context
+// as WithContext does.
+func DeleteContext(ctx context.Context, s Storager, k []byte) error {
+	if cd, ok := s.(ContextDeleter); ok {
+		return cd.DeleteContext(ctx, k)
+	}
+
+	d, ok := s.(Deleter)
+	if !ok {
+		return ErrDeleteUnsupported
+	}
+
+	return runContext(ctx, func() error {
+		return d.Delete(k)
+	})
+}
+
+// wrapper is implemented by the Storagers forwarding their Deletes to the
+// Storagers they wrap.
+type wrapper interface {
+	wrapped() []Storager
+}
+
+// isDeleter tells whether a Delete through s reaches a Deleter, looking
+// through the wrappers forwarding it.
+func isDeleter(s Storager) bool {
+	if w, ok := s.(wrapper); ok {
+		for _, ws := range w.wrapped() {
+			if !isDeleter(ws) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	switch s.(type) {
+	case Deleter, ContextDeleter:
+		return true
+	}
+
+	return false
+}
+
+// Eraser is a Persistent able to remove its value, and the values it is made
+// of, from a Storager.
+type Eraser interface {
+	Erase(Storager, []byte) error
+}
+
+// ContextEraser is an Eraser whose calls take a context.
+type ContextEraser interface {
+	EraseContext(context.Context, Storager, []byte) error
+}
+
+// EraseContext erases p with its EraseContext or Erase method.
+func EraseContext(ctx context.Context, p Persistent, s Storager, k []byte) error {
+	if ce, ok := p.(ContextEraser); ok {
+		return ce.EraseContext(ctx, s, k)
+	}
+
+	e, ok := p.(Eraser)
+	if !ok {
+		return fmt.Errorf("%T cannot be erased", p)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return e.Erase(s, k)
+}
+
+// eraseKey deletes the value of a scalar type.
+func eraseKey(ctx context.Context, prefix byte, s Storager, k []byte) error {
+	return traced(ctx, "erase", prefix, k, func(ctx context.Context) (int, error) {
+		return 0, DeleteContext(ctx, s, append([]byte{prefix}, k...))
+	})
+}
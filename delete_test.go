@@ -0,0 +1,164 @@
+package persistent
+
+import (
+	"errors"
+	"testing"
+)
+
+type testDeleter struct {
+	lockedStorager
+}
+
+func newTestDeleter(db map[string][]byte) *testDeleter {
+	return &testDeleter{lockedStorager{testStorager: testStorager{db}}}
+}
+
+func (td *testDeleter) Delete(k []byte) error {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	delete(td.db, string(k))
+	return nil
+}
+
+func TestDeleteUnsupported(t *testing.T) {
+	storager := &testStorager{map[string][]byte{}}
+
+	if err := Delete(storager, []byte("a")); !errors.Is(err, ErrDeleteUnsupported) {
+		t.Fatalf("expected ErrDeleteUnsupported, got %v", err)
+	}
+
+	if err := NewPersistentInt32(1).Erase(storager, []byte("a")); !errors.Is(err, ErrDeleteUnsupported) {
+		t.Fatalf("expected ErrDeleteUnsupported, got %v", err)
+	}
+
+	if err := Delete(NewCachedStorager(storager, 10, 0), []byte("a")); !errors.Is(err, ErrDeleteUnsupported) {
+		t.Fatalf("expected a wrapper to report ErrDeleteUnsupported, got %v", err)
+	}
+}
+
+func TestErase(t *testing.T) {
+	storager := NewMemoryStorager()
+
+	values := []Persistent{
+		NewPersistentBool(true),
+		NewPersistentString("a"),
+		NewPersistentInt8(1),
+		NewPersistentInt16(1),
+		NewPersistentInt32(1),
+		NewPersistentInt64(1),
+		NewPersistentUint8(1),
+		NewPersistentUint16(1),
+		NewPersistentUint32(1),
+		NewPersistentUint64(1),
+		NewPersistentFloat32(1),
+		NewPersistentFloat64(1),
+		NewPersistentByte(1),
+	}
+
+	for _, p := range values {
+		if err := p.Persist(storager, []byte("k")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range values {
+		if err := p.(Eraser).Erase(storager, []byte("k")); err != nil {
+			t.Fatalf("%T: %v", p, err)
+		}
+
+		if err := p.Restore(storager, []byte("k")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%T: expected ErrNotFound, got %v", p, err)
+		}
+	}
+}
+
+func TestSliceChangeType(t *testing.T) {
+	storager := newTestDeleter(map[string][]byte{})
+
+	slc := PersistentSlice{NewPersistentInt64(1), NewPersistentInt64(2)}
+	if err := slc.Persist(storager, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+
+	strs := PersistentSlice{NewPersistentString("a")}
+	if err := strs.Persist(storager, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := storager.db["\x05s:\x051"]; ok {
+		t.Fatal("expected the int64 elements to be deleted")
+	}
+
+	restored := PersistentSlice{}
+	if err := restored.Restore(storager, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored) != 1 || *restored[0].(*PersistentString) != "a" {
+		t.Fatalf("unexpected slice %v", restored)
+	}
+
+	if err := restored.Erase(storager, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := len(storager.db); keys != 0 {
+		t.Fatalf("expected Erase to delete every key, %d left", keys)
+	}
+}
+
+func TestSliceWrappedNonDeleter(t *testing.T) {
+	// the wrapper forwards Delete to a Storager with its own missing key error
+	storager := NewCachedStorager(newLockedStorager(), 10, 0)
+
+	slc := PersistentSlice{NewPersistentInt64(1), NewPersistentInt64(2)}
+	if err := slc.Persist(storager, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+
+	strs := PersistentSlice{NewPersistentString("a")}
+	if err := strs.Persist(storager, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := PersistentSlice{}
+	if err := restored.Restore(storager, []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored) != 1 || *restored[0].(*PersistentString) != "a" {
+		t.Fatalf("unexpected slice %v", restored)
+	}
+}
+
+func TestDeleteStruct(t *testing.T) {
+	storager := NewMemoryStorager()
+
+	type person struct {
+		Name *PersistentString
+		Age  *PersistentUint32
+	}
+
+	PersistStruct("p", &person{
+		Name: NewPersistentString("Carlos"),
+		Age:  NewPersistentUint32(21),
+	}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	// the fields need not be set to be deleted
+	DeleteStruct("p", &person{}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	RestoreStruct("p", &person{}, storager, func(e error) {
+		if !errors.Is(e, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", e)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package persistent
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// KeyProvider hands out the AES keys (16, 24 or 32 bytes) used by an
+// EncryptedStorager. New values are encrypted with the current key, and Key
+// must keep returning older keys for as long as values encrypted with them
+// exist.
+type KeyProvider interface {
+	CurrentKey() (id uint32, key []byte, err error)
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider backed by a map of keys.
+type StaticKeyProvider struct {
+	lock    sync.RWMutex
+	keys    map[uint32][]byte
+	current uint32
+}
+
+func NewStaticKeyProvider(id uint32, key []byte) *StaticKeyProvider {
+	return &StaticKeyProvider{
+		keys:    map[uint32][]byte{id: key},
+		current: id,
+	}
+}
+
+// Rotate adds a key and makes it the current one.
+func (kp *StaticKeyProvider) Rotate(id uint32, key []byte) {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	kp.keys[id] = key
+	kp.current = id
+}
+
+func (kp *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	kp.lock.RLock()
+	defer kp.lock.RUnlock()
+
+	return kp.current, kp.keys[kp.current], nil
+}
+
+func (kp *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	kp.lock.RLock()
+	defer kp.lock.RUnlock()
+
+	key, ok := kp.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %d", id)
+	}
+
+	return key, nil
+}
+
+const encryptedVersion = byte(1)
+
+// version | key id
+const encryptedHeaderSize = 1 + 4
+
+// EncryptedStorager encrypts values with AES-GCM before handing them to the
+// wrapped Storager. Every value starts with the id of the key that encrypted
+// it, so keys can be rotated without rewriting old values. The header and the
+// key are authenticated, so a value cannot be moved to another key.
+//
+// When KeyMAC is set, keys are replaced by their HMAC-SHA256 with it and the
+// wrapped Storager never sees them in clear.
+type EncryptedStorager struct {
+	Storager Storager
+	Keys     KeyProvider
+	KeyMAC   []byte
+}
+
+func NewEncryptedStorager(s Storager, keys KeyProvider) *EncryptedStorager {
+	return &EncryptedStorager{Storager: s, Keys: keys}
+}
+
+func (es *EncryptedStorager) key(k []byte) []byte {
+	if es.KeyMAC == nil {
+		return k
+	}
+
+	mac := hmac.New(sha256.New, es.KeyMAC)
+	mac.Write(k)
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (es *EncryptedStorager) Save(k, v []byte) error {
+	return es.SaveContext(context.Background(), k, v)
+}
+
+func (es *EncryptedStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	dt, err := es.encrypt(k, v)
+	if err != nil {
+		return err
+	}
+
+	return saveContext(ctx, es.Storager, es.key(k), dt)
+}
+
+func (es *EncryptedStorager) encrypt(k, v []byte) ([]byte, error) {
+	id, key, err := es.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dt := make([]byte, encryptedHeaderSize+gcm.NonceSize(), encryptedHeaderSize+gcm.NonceSize()+len(v)+gcm.Overhead())
+	dt[0] = encryptedVersion
+	binary.BigEndian.PutUint32(dt[1:], id)
+
+	nonce := dt[encryptedHeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	ad := append(dt[:encryptedHeaderSize:encryptedHeaderSize], k...)
+	return gcm.Seal(dt, nonce, v, ad), nil
+}
+
+func (es *EncryptedStorager) Load(k []byte) ([]byte, error) {
+	return es.LoadContext(context.Background(), k)
+}
+
+func (es *EncryptedStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	dt, err := loadContext(ctx, es.Storager, es.key(k))
+	if err != nil {
+		return nil, err
+	}
+
+	return es.decrypt(k, dt)
+}
+
+func (es *EncryptedStorager) decrypt(k, dt []byte) ([]byte, error) {
+	if len(dt) < encryptedHeaderSize || dt[0] != encryptedVersion {
+		return nil, fmt.Errorf("%q is not an encrypted value", k)
+	}
+
+	key, err := es.Keys.Key(binary.BigEndian.Uint32(dt[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dt) < encryptedHeaderSize+gcm.NonceSize()+gcm.Overhead() {
+		return nil, fmt.Errorf("%q is not an encrypted value", k)
+	}
+
+	nonce := dt[encryptedHeaderSize : encryptedHeaderSize+gcm.NonceSize()]
+	ad := append(dt[:encryptedHeaderSize:encryptedHeaderSize], k...)
+
+	v, err := gcm.Open(nil, nonce, dt[encryptedHeaderSize+gcm.NonceSize():], ad)
+	if err != nil {
+		return nil, fmt.Errorf("value of %q failed authentication", k)
+	}
+
+	if v == nil {
+		v = []byte{}
+	}
+
+	return v, nil
+}
+
+func (es *EncryptedStorager) wrapped() []Storager {
+	return []Storager{es.Storager}
+}
+
+func (es *EncryptedStorager) Delete(k []byte) error {
+	return es.DeleteContext(context.Background(), k)
+}
+
+func (es *EncryptedStorager) DeleteContext(ctx context.Context, k []byte) error {
+	return DeleteContext(ctx, es.Storager, es.key(k))
+}
+
+// Scan returns ErrScanUnsupported when KeyMAC is set, as the keys cannot be
+// recovered from their HMAC.
+func (es *EncryptedStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return es.ScanContext(context.Background(), opts, fn)
+}
+
+func (es *EncryptedStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	if es.KeyMAC != nil {
+		return ErrScanUnsupported
+	}
+
+	return scanValues(ctx, es.Storager, opts, es.decrypt, fn)
+}
+
+func (es *EncryptedStorager) Batch(ops []BatchOp) error {
+	return es.BatchContext(context.Background(), ops)
+}
+
+func (es *EncryptedStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	encrypted, err := mapBatch(ops, func(op BatchOp) (BatchOp, error) {
+		var err error
+		if !op.Delete {
+			op.Value, err = es.encrypt(op.Key, op.Value)
+		}
+
+		op.Key = es.key(op.Key)
+		return op, err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return BatchContext(ctx, es.Storager, encrypted)
+}
@@ -0,0 +1,114 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptedStorager(t *testing.T) {
+	backend := NewMemoryStorager()
+	keys := NewStaticKeyProvider(1, bytes.Repeat([]byte{1}, 32))
+	storager := NewEncryptedStorager(backend, keys)
+
+	k := []byte{PersistentStringPrefix, 'p', '/', 'T'}
+	if err := storager.Save(k, []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := backend.Load(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(raw, []byte("token")) {
+		t.Fatal("value was stored in clear")
+	}
+
+	keys.Rotate(2, bytes.Repeat([]byte{2}, 16))
+	if err := storager.Save([]byte("new"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if dt, err := storager.Load(k); err != nil || string(dt) != "token" {
+		t.Fatalf("expected a value of the rotated key, got %q, %v", dt, err)
+	}
+
+	if dt, err := storager.Load([]byte("new")); err != nil || dt == nil || len(dt) != 0 {
+		t.Fatalf("expected an empty value, got %v, %v", dt, err)
+	}
+
+	// moving a value to another key fails authentication
+	backend.Save([]byte("moved"), raw)
+	if _, err := storager.Load([]byte("moved")); err == nil {
+		t.Fatal("expected an authentication error")
+	}
+
+	raw[len(raw)-1] ^= 1
+	backend.Save(k, raw)
+	if _, err := storager.Load(k); err == nil {
+		t.Fatal("expected an authentication error")
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestEncryptedStoragerKeyMAC(t *testing.T) {
+	backend := NewMemoryStorager()
+	storager := NewEncryptedStorager(backend, NewStaticKeyProvider(1, bytes.Repeat([]byte{1}, 32)))
+	storager.KeyMAC = []byte("mac key")
+
+	type pessoa struct {
+		Nome  *PersistentString
+		Email *PersistentString
+	}
+
+	PersistStruct("pcarlos", &pessoa{
+		Nome:  NewPersistentString("Carlos"),
+		Email: NewPersistentString("carlos@example.com"),
+	}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if _, err := backend.Load(append([]byte{PersistentStringPrefix}, "pcarlos/Nome"...)); !errors.Is(err, ErrNotFound) {
+		t.Fatal("backend sees the key in clear")
+	}
+
+	nCarlos := &pessoa{}
+	RestoreStruct("pcarlos", nCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *nCarlos.Nome != "Carlos" || *nCarlos.Email != "carlos@example.com" {
+		t.Fail()
+	}
+}
+
+func TestEncryptedStoragerScan(t *testing.T) {
+	backend := NewMemoryStorager()
+	storager := NewEncryptedStorager(backend, NewStaticKeyProvider(1, bytes.Repeat([]byte{1}, 32)))
+
+	storager.Save([]byte("a/1"), []byte("secret"))
+	storager.Save([]byte("b"), []byte("other"))
+
+	err := storager.Scan(ScanOptions{Prefix: []byte("a/")}, func(k, v []byte) bool {
+		if string(k) != "a/1" || string(v) != "secret" {
+			t.Fatalf("unexpected entry %q: %q", k, v)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storager.KeyMAC = []byte("mac")
+	if err := storager.Scan(ScanOptions{}, func(k, v []byte) bool { return true }); !errors.Is(err, ErrScanUnsupported) {
+		t.Fatalf("expected ErrScanUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package persistent
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test doubles shared with the external tests of the package.
+
+// StartRedisFake starts an in-process Redis stand-in and returns its address.
+func StartRedisFake(t *testing.T) string {
+	return newTestRedisServer(t, "").listener.Addr().String()
+}
+
+// NewS3Fake returns a S3Storager on an in-process S3 stand-in.
+func NewS3Fake(t *testing.T) *S3Storager {
+	srv := httptest.NewServer(&testS3Server{accessKey: "access", secretKey: "secret", objects: map[string][]byte{}})
+	t.Cleanup(srv.Close)
+
+	return NewS3Storager(srv.URL, "us-east-1", "bucket", "access", "secret")
+}
+
+// OpenSQLite opens a SQLite database in a temporary directory.
+func OpenSQLite(t *testing.T) *sql.DB {
+	return openTestSQL(t)
+}
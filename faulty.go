@@ -0,0 +1,280 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error returned by faults that set no error of their own.
+var ErrInjected = errors.New("injected fault")
+
+type FaultOp int
+
+const (
+	FaultAny FaultOp = iota
+	FaultSave
+	FaultLoad
+	FaultDelete
+	FaultScan
+	FaultBatch
+)
+
+func (op FaultOp) String() string {
+	switch op {
+	case FaultSave:
+		return "Save"
+	case FaultLoad:
+		return "Load"
+	case FaultDelete:
+		return "Delete"
+	case FaultScan:
+		return "Scan"
+	case FaultBatch:
+		return "Batch"
+	}
+
+	return "Any"
+}
+
+// Fault describes a failure injected by FaultyStorager into the calls
+// matching Op and Key, which is matched against the prefix of a Scan and the
+// first key of a Batch. When Nth is set, only the Nth matching call, counting
+// from 1, is affected.
+type Fault struct {
+	Op  FaultOp
+	Key *regexp.Regexp
+	Nth int
+
+	// Err fails the call. Use ErrInjected when the error does not matter.
+	Err error
+
+	// Latency delays the call.
+	Latency time.Duration
+
+	// Corrupt alters the value returned by a successful Load.
+	Corrupt func([]byte) []byte
+
+	calls int
+}
+
+// Truncate returns a Fault.Corrupt function keeping the first n bytes.
+func Truncate(n int) func([]byte) []byte {
+	return func(dt []byte) []byte {
+		if n < len(dt) {
+			return dt[:n]
+		}
+		return dt
+	}
+}
+
+// FlipBit returns a Fault.Corrupt function flipping the bit i of the value.
+func FlipBit(i int) func([]byte) []byte {
+	return func(dt []byte) []byte {
+		if i/8 < len(dt) {
+			dt[i/8] ^= 1 << (i % 8)
+		}
+		return dt
+	}
+}
+
+// FaultCall is an entry of the call log of a FaultyStorager.
+type FaultCall struct {
+	Op       FaultOp
+	Key      []byte
+	Err      error
+	Injected bool
+}
+
+// FaultyStorager wraps a Storager and injects the scripted faults into its
+// calls, recording every call, to test how code behaves when persistence
+// fails.
+type FaultyStorager struct {
+	Storager Storager
+
+	lock   sync.Mutex
+	faults []*Fault
+	calls  []FaultCall
+}
+
+func NewFaultyStorager(s Storager) *FaultyStorager {
+	return &FaultyStorager{Storager: s}
+}
+
+func (fs *FaultyStorager) AddFault(f Fault) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.faults = append(fs.faults, &f)
+}
+
+// Calls returns the log of every call made so far.
+func (fs *FaultyStorager) Calls() []FaultCall {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return append([]FaultCall(nil), fs.calls...)
+}
+
+// Reset drops the faults and the call log.
+func (fs *FaultyStorager) Reset() {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.faults, fs.calls = nil, nil
+}
+
+// match returns the faults triggered by a call.
+func (fs *FaultyStorager) match(op FaultOp, k []byte) []Fault {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	matched := []Fault{}
+	for _, f := range fs.faults {
+		if f.Op != FaultAny && f.Op != op || f.Key != nil && !f.Key.Match(k) {
+			continue
+		}
+
+		f.calls++
+		if f.Nth == 0 || f.Nth == f.calls {
+			matched = append(matched, *f)
+		}
+	}
+
+	return matched
+}
+
+func (fs *FaultyStorager) log(op FaultOp, k []byte, err error, injected bool) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.calls = append(fs.calls, FaultCall{
+		Op:       op,
+		Key:      append([]byte(nil), k...),
+		Err:      err,
+		Injected: injected,
+	})
+}
+
+// inject applies the latency and errors of the faults triggered by a call.
+// The latency is cut short once ctx is done.
+func (fs *FaultyStorager) inject(ctx context.Context, op FaultOp, k []byte) ([]Fault, error) {
+	faults := fs.match(op, k)
+
+	for _, f := range faults {
+		if f.Latency <= 0 {
+			continue
+		}
+
+		timer := time.NewTimer(f.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			fs.log(op, k, ctx.Err(), false)
+			return nil, ctx.Err()
+		}
+	}
+
+	for _, f := range faults {
+		if f.Err != nil {
+			fs.log(op, k, f.Err, true)
+			return nil, f.Err
+		}
+	}
+
+	return faults, nil
+}
+
+func (fs *FaultyStorager) Save(k, v []byte) error {
+	return fs.SaveContext(context.Background(), k, v)
+}
+
+func (fs *FaultyStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	if _, err := fs.inject(ctx, FaultSave, k); err != nil {
+		return err
+	}
+
+	err := saveContext(ctx, fs.Storager, k, v)
+	fs.log(FaultSave, k, err, false)
+	return err
+}
+
+func (fs *FaultyStorager) Load(k []byte) ([]byte, error) {
+	return fs.LoadContext(context.Background(), k)
+}
+
+func (fs *FaultyStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	faults, err := fs.inject(ctx, FaultLoad, k)
+	if err != nil {
+		return nil, err
+	}
+
+	dt, err := loadContext(ctx, fs.Storager, k)
+
+	injected := false
+	for _, f := range faults {
+		if err == nil && f.Corrupt != nil {
+			dt = f.Corrupt(append([]byte(nil), dt...))
+			injected = true
+		}
+	}
+
+	fs.log(FaultLoad, k, err, injected)
+	return dt, err
+}
+
+func (fs *FaultyStorager) wrapped() []Storager {
+	return []Storager{fs.Storager}
+}
+
+func (fs *FaultyStorager) Delete(k []byte) error {
+	return fs.DeleteContext(context.Background(), k)
+}
+
+func (fs *FaultyStorager) DeleteContext(ctx context.Context, k []byte) error {
+	if _, err := fs.inject(ctx, FaultDelete, k); err != nil {
+		return err
+	}
+
+	err := DeleteContext(ctx, fs.Storager, k)
+	fs.log(FaultDelete, k, err, false)
+	return err
+}
+
+// Scan is logged with its prefix as key. Corrupt does not apply to it.
+func (fs *FaultyStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return fs.ScanContext(context.Background(), opts, fn)
+}
+
+func (fs *FaultyStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	if _, err := fs.inject(ctx, FaultScan, opts.Prefix); err != nil {
+		return err
+	}
+
+	err := ScanContext(ctx, fs.Storager, opts, fn)
+	fs.log(FaultScan, opts.Prefix, err, false)
+	return err
+}
+
+// Batch is logged with the key of its first op.
+func (fs *FaultyStorager) Batch(ops []BatchOp) error {
+	return fs.BatchContext(context.Background(), ops)
+}
+
+func (fs *FaultyStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	var k []byte
+	if len(ops) > 0 {
+		k = ops[0].Key
+	}
+
+	if _, err := fs.inject(ctx, FaultBatch, k); err != nil {
+		return err
+	}
+
+	err := BatchContext(ctx, fs.Storager, ops)
+	fs.log(FaultBatch, k, err, false)
+	return err
+}
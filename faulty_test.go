@@ -0,0 +1,89 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFaultyStorager(t *testing.T) {
+	backend := NewMemoryStorager()
+	storager := NewFaultyStorager(backend)
+
+	storager.AddFault(Fault{Op: FaultSave, Nth: 2, Err: ErrInjected})
+
+	for i := 0; i < 3; i++ {
+		err := storager.Save([]byte{byte(i)}, []byte{byte(i)})
+		if i == 1 && !errors.Is(err, ErrInjected) || i != 1 && err != nil {
+			t.Fatalf("call %d: unexpected error %v", i+1, err)
+		}
+	}
+
+	if _, err := backend.Load([]byte{1}); !errors.Is(err, ErrNotFound) {
+		t.Fatal("the failed Save reached the backend")
+	}
+
+	calls := storager.Calls()
+	if len(calls) != 3 || !calls[1].Injected || calls[0].Injected || calls[1].Op != FaultSave {
+		t.Fatalf("unexpected call log %+v", calls)
+	}
+
+	storager.Reset()
+	storager.AddFault(Fault{Op: FaultLoad, Key: regexp.MustCompile(`/Idade$`), Corrupt: Truncate(0)})
+	storager.AddFault(Fault{Key: regexp.MustCompile(`/Nome$`), Latency: 20 * time.Millisecond})
+
+	type pessoa struct {
+		Nome  *PersistentString
+		Idade *PersistentUint32
+	}
+
+	PersistStruct("pcarlos", &pessoa{
+		Nome:  NewPersistentString("Carlos"),
+		Idade: NewPersistentUint32(21),
+	}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	errs := []error{}
+	start := time.Now()
+	RestoreStruct("pcarlos", &pessoa{}, storager, func(e error) {
+		if e != nil {
+			errs = append(errs, e)
+		}
+	})
+
+	if len(errs) != 1 {
+		t.Fatalf("expected the truncated age to fail, got %v", errs)
+	}
+
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("latency was not injected")
+	}
+
+	// the struct was saved in a single batch
+	calls = storager.Calls()
+	if len(calls) != 3 || calls[0].Op != FaultBatch {
+		t.Fatalf("expected a batch and 2 loads, got %+v", calls)
+	}
+}
+
+func TestFaultyStoragerFlipBit(t *testing.T) {
+	backend := NewMemoryStorager()
+	storager := NewFaultyStorager(backend)
+	storager.Save([]byte("a"), []byte{0, 0})
+
+	storager.AddFault(Fault{Op: FaultLoad, Corrupt: FlipBit(9)})
+
+	dt, err := storager.Load([]byte("a"))
+	if err != nil || !bytes.Equal(dt, []byte{0, 2}) {
+		t.Fatalf("expected a flipped bit, got %v, %v", dt, err)
+	}
+
+	if raw, _ := backend.Load([]byte("a")); !bytes.Equal(raw, []byte{0, 0}) {
+		t.Fatal("the stored value was altered")
+	}
+}
@@ -0,0 +1,214 @@
+package persistent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const fileSegmentLength = 200
+
+// FileStorager stores every key as its own file below Dir. Keys are escaped
+// so that any byte, including the type prefixes, maps to a portable file
+// name. Writes go to a temporary file that is renamed over the target, and
+// are fsynced when Sync is set.
+type FileStorager struct {
+	Dir  string
+	Sync bool
+}
+
+func NewFileStorager(dir string) (*FileStorager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileStorager{Dir: dir}, nil
+}
+
+func isFileSafe(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+// escapeFileKey maps a key to a relative path. Only lower case letters,
+// digits, '-' and '_' are kept, every other byte becomes %xx, so names are
+// safe on case insensitive file systems and never start with a dot. Long keys
+// are split in directories whose names end with '+', a byte that escaped
+// names never contain, so a directory never collides with a file.
+func escapeFileKey(k []byte) string {
+	segments := []string{}
+	segment := new(strings.Builder)
+
+	for _, c := range k {
+		esc := string(c)
+		if !isFileSafe(c) {
+			esc = fmt.Sprintf("%%%02x", c)
+		}
+
+		if segment.Len()+len(esc) > fileSegmentLength {
+			segments = append(segments, segment.String()+"+")
+			segment.Reset()
+		}
+
+		segment.WriteString(esc)
+	}
+
+	if segment.Len() == 0 {
+		segment.WriteString("%")
+	}
+
+	return filepath.Join(append(segments, segment.String())...)
+}
+
+func unescapeFileKey(p string) ([]byte, error) {
+	k := []byte{}
+	for _, segment := range strings.Split(filepath.ToSlash(p), "/") {
+		segment = strings.TrimSuffix(segment, "+")
+		if segment == "%" {
+			continue
+		}
+
+		for i := 0; i < len(segment); i++ {
+			if segment[i] != '%' {
+				k = append(k, segment[i])
+				continue
+			}
+
+			if i+2 >= len(segment) {
+				return nil, fmt.Errorf("%s is not an escaped key", p)
+			}
+
+			c, err := strconv.ParseUint(segment[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not an escaped key", p)
+			}
+
+			k = append(k, byte(c))
+			i += 2
+		}
+	}
+
+	return k, nil
+}
+
+func (fs *FileStorager) path(k []byte) string {
+	return filepath.Join(fs.Dir, escapeFileKey(k))
+}
+
+func (fs *FileStorager) Save(k, v []byte) error {
+	path := fs.path(k)
+	dir := filepath.Dir(path)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(v)
+	if err == nil && fs.Sync {
+		err = tmp.Sync()
+	}
+
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if fs.Sync {
+		return syncDir(dir)
+	}
+
+	return nil
+}
+
+func (fs *FileStorager) Load(k []byte) ([]byte, error) {
+	dt, err := os.ReadFile(fs.path(k))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return dt, err
+}
+
+// Delete removes the file of k and the directories of a long key left empty.
+func (fs *FileStorager) Delete(k []byte) error {
+	path := fs.path(k)
+
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	for strings.HasSuffix(dir, "+") && os.Remove(dir) == nil {
+		dir = filepath.Dir(dir)
+	}
+
+	if fs.Sync {
+		return syncDir(dir)
+	}
+
+	return nil
+}
+
+// Scan lists the files below Dir, so it costs the same whatever opts select.
+func (fs *FileStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	keys := [][]byte{}
+
+	err := filepath.WalkDir(fs.Dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(fs.Dir, path)
+		if err != nil {
+			return err
+		}
+
+		k, err := unescapeFileKey(rel)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, k)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return scanKeys(keys, opts, fs.Load, fn)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
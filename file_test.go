@@ -0,0 +1,112 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFileStorager(t *testing.T) {
+	storager, err := NewFileStorager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storager.Sync = true
+
+	keys := [][]byte{
+		[]byte("test"),
+		[]byte("Test"),
+		{PersistentSlicePrefix, 't', '/', '.', '.'},
+		[]byte(strings.Repeat("/", 300)),
+		{},
+	}
+
+	for i, k := range keys {
+		if err := storager.Save(k, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, k := range keys {
+		dt, err := storager.Load(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(dt, []byte{byte(i)}) {
+			t.Fatalf("%q: expected %d, got %v", k, i, dt)
+		}
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFileStoragerEscape(t *testing.T) {
+	keys := [][]byte{
+		[]byte("pcarlos/Nome"),
+		{PersistentStringPrefix, 0, 255},
+		[]byte(strings.Repeat("%", 500)),
+	}
+
+	for _, k := range keys {
+		nK, err := unescapeFileKey(escapeFileKey(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(nK, k) {
+			t.Fatalf("expected %q, got %q", k, nK)
+		}
+	}
+}
+
+func TestFileStoragerStruct(t *testing.T) {
+	dir := t.TempDir()
+	storager, err := NewFileStorager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type pessoa struct {
+		Nome   *PersistentString
+		Idade  *PersistentUint32
+		Filhos *PersistentSlice
+	}
+
+	pCarlos := &pessoa{
+		Nome:  NewPersistentString("Carlos"),
+		Idade: NewPersistentUint32(21),
+		Filhos: &PersistentSlice{
+			NewPersistentString("Ana"),
+			NewPersistentString("Rui"),
+		},
+	}
+
+	PersistStruct("pcarlos", pCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	reopened, err := NewFileStorager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nCarlos := &pessoa{}
+	RestoreStruct("pcarlos", nCarlos, reopened, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *pCarlos.Nome != *nCarlos.Nome ||
+		*pCarlos.Idade != *nCarlos.Idade ||
+		len(*nCarlos.Filhos) != 2 {
+
+		t.Fail()
+	}
+}
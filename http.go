@@ -0,0 +1,468 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// StoragerHandler exposes a Storager over HTTP. Keys are the base64url
+// (unpadded) encoded path below the handler: PUT saves the request body, GET
+// returns the stored value, or 404 when the key has none, and DELETE removes
+// it, or answers 501 when the Storager is not a Deleter. A GET of the root
+// with a scan parameter scans the Storager, as described by scanRequest, and
+// a POST of the root writes the JSON array of httpBatchOp it carries as a
+// batch, or answers 501 when the Storager is not a Batcher.
+// When Token is set, requests must carry it as a bearer token. Request bodies
+// larger than MaxBodySize are answered with 413.
+type StoragerHandler struct {
+	Storager Storager
+	Token    string
+
+	// MaxBodySize is the largest body, in bytes, of a PUT or a POST.
+	// DefaultMaxBodySize is used when it is not above zero.
+	MaxBodySize int64
+}
+
+// DefaultMaxBodySize is the body size limit of a StoragerHandler without
+// MaxBodySize.
+const DefaultMaxBodySize = 32 << 20
+
+func NewStoragerHandler(s Storager) *StoragerHandler {
+	return &StoragerHandler{Storager: s, MaxBodySize: DefaultMaxBodySize}
+}
+
+func (h *StoragerHandler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return true
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	token := header[len("Bearer "):]
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+// readBody reads the body of r, answering the request when it fails.
+func (h *StoragerHandler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	limit := h.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+
+	dt, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		status := http.StatusBadRequest
+		// the reader stops at the limit when the body goes past it
+		if int64(len(dt)) >= limit {
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		http.Error(w, err.Error(), status)
+		return nil, false
+	}
+
+	return dt, true
+}
+
+func (h *StoragerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == "/" && r.URL.Query().Has("scan") {
+		h.scan(w, r)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == "/" {
+		h.batch(w, r)
+		return
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		http.Error(w, "key is not base64url encoded", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		dt, ok := h.readBody(w, r)
+		if !ok {
+			return
+		}
+
+		if err := WithContext(h.Storager).SaveContext(r.Context(), key, dt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		dt, err := WithContext(h.Storager).LoadContext(r.Context(), key)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(dt)
+	case http.MethodDelete:
+		err := DeleteContext(r.Context(), h.Storager, key)
+		if errors.Is(err, ErrDeleteUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// httpScanEntry is a line of the JSON stream answering a scan. A scan that
+// fails once started ends with an entry holding only Error.
+type httpScanEntry struct {
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// scanRequest encodes opts as the query of a scan: the bounds are base64url
+// encoded as keys are, and missing when nil.
+func scanRequest(opts ScanOptions) url.Values {
+	query := url.Values{"scan": {""}}
+
+	bounds := map[string][]byte{"prefix": opts.Prefix, "start": opts.Start, "end": opts.End}
+	for name, bound := range bounds {
+		if bound != nil {
+			query.Set(name, base64.RawURLEncoding.EncodeToString(bound))
+		}
+	}
+
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	if opts.KeysOnly {
+		query.Set("keys_only", "")
+	}
+
+	return query
+}
+
+func parseScanRequest(query url.Values) (ScanOptions, error) {
+	opts := ScanOptions{KeysOnly: query.Has("keys_only")}
+
+	bounds := map[string]*[]byte{"prefix": &opts.Prefix, "start": &opts.Start, "end": &opts.End}
+	for name, bound := range bounds {
+		if !query.Has(name) {
+			continue
+		}
+
+		dt, err := base64.RawURLEncoding.DecodeString(query.Get(name))
+		if err != nil {
+			return opts, fmt.Errorf("%s is not base64url encoded", name)
+		}
+		*bound = dt
+	}
+
+	if query.Has("limit") {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil {
+			return opts, errors.New("limit is not a number")
+		}
+		opts.Limit = limit
+	}
+
+	return opts, nil
+}
+
+func (h *StoragerHandler) scan(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseScanRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	started := false
+
+	err = ScanContext(r.Context(), h.Storager, opts, func(k, v []byte) bool {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+
+		return enc.Encode(httpScanEntry{Key: k, Value: v}) == nil
+	})
+
+	switch {
+	case err == nil:
+	case started:
+		enc.Encode(httpScanEntry{Error: err.Error()})
+	case errors.Is(err, ErrScanUnsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// httpBatchOp is a BatchOp in the body of a batch request.
+type httpBatchOp struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+func (h *StoragerHandler) batch(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	request := []httpBatchOp{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ops := make([]BatchOp, len(request))
+	for i, op := range request {
+		ops[i] = BatchOp{Key: op.Key, Value: op.Value, Delete: op.Delete}
+		if ops[i].Key == nil {
+			ops[i].Key = []byte{}
+		}
+
+		if ops[i].Value == nil && !op.Delete {
+			ops[i].Value = []byte{}
+		}
+	}
+
+	err := BatchContext(r.Context(), h.Storager, ops)
+	if errors.Is(err, ErrBatchUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTPError is an error response of a StoragerHandler.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func newHTTPError(res *http.Response, body []byte) *HTTPError {
+	return &HTTPError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Message:    string(bytes.TrimSpace(body)),
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Message)
+}
+
+// HTTPStorager is the client of a StoragerHandler mounted at BaseURL.
+type HTTPStorager struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+func NewHTTPStorager(baseURL string) *HTTPStorager {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32
+
+	return &HTTPStorager{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Client:  &http.Client{Transport: transport},
+	}
+}
+
+func (hs *HTTPStorager) request(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if hs.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+hs.Token)
+	}
+
+	return hs.Client.Do(req)
+}
+
+func (hs *HTTPStorager) do(ctx context.Context, method string, k []byte, body io.Reader) ([]byte, error) {
+	res, err := hs.request(ctx, method, hs.BaseURL+"/"+base64.RawURLEncoding.EncodeToString(k), body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// the body is always drained so the connection can be reused
+	dt, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case res.StatusCode == http.StatusNotImplemented && method == http.MethodDelete:
+		return nil, ErrDeleteUnsupported
+	case res.StatusCode >= 300:
+		return nil, fmt.Errorf("%s %q: %w", method, k, newHTTPError(res, dt))
+	}
+
+	return dt, nil
+}
+
+func (hs *HTTPStorager) Save(k, v []byte) error {
+	return hs.SaveContext(context.Background(), k, v)
+}
+
+func (hs *HTTPStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	_, err := hs.do(ctx, http.MethodPut, k, bytes.NewReader(v))
+	return err
+}
+
+func (hs *HTTPStorager) Load(k []byte) ([]byte, error) {
+	return hs.LoadContext(context.Background(), k)
+}
+
+func (hs *HTTPStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	return hs.do(ctx, http.MethodGet, k, nil)
+}
+
+func (hs *HTTPStorager) Delete(k []byte) error {
+	return hs.DeleteContext(context.Background(), k)
+}
+
+func (hs *HTTPStorager) DeleteContext(ctx context.Context, k []byte) error {
+	_, err := hs.do(ctx, http.MethodDelete, k, nil)
+	return err
+}
+
+func (hs *HTTPStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return hs.ScanContext(context.Background(), opts, fn)
+}
+
+// ScanContext reads the entries as the handler streams them, and closes the
+// response as soon as fn returns false.
+func (hs *HTTPStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	res, err := hs.request(ctx, http.MethodGet, hs.BaseURL+"/?"+scanRequest(opts).Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		dt, _ := io.ReadAll(res.Body)
+		if res.StatusCode == http.StatusNotImplemented {
+			return ErrScanUnsupported
+		}
+
+		return fmt.Errorf("scan: %w", newHTTPError(res, dt))
+	}
+
+	dec := json.NewDecoder(res.Body)
+	for {
+		entry := httpScanEntry{}
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if entry.Error != "" {
+			return fmt.Errorf("scan: %s", entry.Error)
+		}
+
+		if entry.Key == nil {
+			entry.Key = []byte{}
+		}
+
+		if entry.Value == nil && !opts.KeysOnly {
+			entry.Value = []byte{}
+		}
+
+		if !fn(entry.Key, entry.Value) {
+			return nil
+		}
+	}
+}
+
+func (hs *HTTPStorager) Batch(ops []BatchOp) error {
+	return hs.BatchContext(context.Background(), ops)
+}
+
+func (hs *HTTPStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	request := make([]httpBatchOp, len(ops))
+	for i, op := range ops {
+		request[i] = httpBatchOp{Key: op.Key, Value: op.Value, Delete: op.Delete}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	res, err := hs.request(ctx, http.MethodPost, hs.BaseURL+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	dt, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotImplemented:
+		return ErrBatchUnsupported
+	case res.StatusCode >= 300:
+		return fmt.Errorf("batch: %w", newHTTPError(res, dt))
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package persistent
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHTTPStorager(t *testing.T) {
+	handler := NewStoragerHandler(NewMemoryStorager())
+	handler.Token = "secret"
+
+	conns := 0
+	lock := new(sync.Mutex)
+
+	srv := httptest.NewUnstartedServer(http.StripPrefix("/store", handler))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			conns++
+			lock.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	storager := NewHTTPStorager(srv.URL + "/store/")
+	storager.Token = "secret"
+
+	keys := [][]byte{
+		{PersistentSlicePrefix, 't', 'e', 's', 't', ':', 0x0c, '0'},
+		[]byte("pcarlos/Nome"),
+		{},
+	}
+
+	for i, k := range keys {
+		if err := storager.Save(k, []byte{byte(i), 0xff}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, k := range keys {
+		dt, err := storager.Load(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(dt) != 2 || dt[0] != byte(i) {
+			t.Fatalf("%q: unexpected value %v", k, dt)
+		}
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	lock.Lock()
+	if conns != 1 {
+		t.Fatalf("expected the connection to be reused, got %d connections", conns)
+	}
+	lock.Unlock()
+
+	storager.Token = "wrong"
+	if err := storager.Save([]byte("a"), []byte("1")); err == nil {
+		t.Fatal("expected an unauthorized error")
+	}
+}
+
+func TestStoragerHandlerLimits(t *testing.T) {
+	handler := NewStoragerHandler(NewMemoryStorager())
+	handler.Token = "secret"
+	handler.MaxBodySize = 8
+
+	requests := []struct {
+		method, auth, body string
+		status             int
+	}{
+		{http.MethodPut, "secret", "1", http.StatusUnauthorized},
+		{http.MethodPut, "bearer secret", "1", http.StatusUnauthorized},
+		{http.MethodPut, "Bearer secret", "12345678", http.StatusNoContent},
+		{http.MethodPut, "Bearer secret", "123456789", http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "Bearer secret", `[{"key":"YQ=="}]`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, req := range requests {
+		path := "/YQ"
+		if req.method == http.MethodPost {
+			path = "/"
+		}
+
+		r := httptest.NewRequest(req.method, path, strings.NewReader(req.body))
+		r.Header.Set("Authorization", req.auth)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != req.status {
+			t.Fatalf("%s %q with %q: expected %d, got %d", req.method, req.body, req.auth, req.status, w.Code)
+		}
+	}
+}
+
+func TestHTTPStoragerStruct(t *testing.T) {
+	srv := httptest.NewServer(NewStoragerHandler(NewMemoryStorager()))
+	defer srv.Close()
+
+	storager := NewHTTPStorager(srv.URL)
+
+	type pessoa struct {
+		Nome   *PersistentString
+		Altura *PersistentFloat32
+		Filhos *PersistentSlice
+	}
+
+	pCarlos := &pessoa{
+		Nome:   NewPersistentString("Carlos"),
+		Altura: NewPersistentFloat32(1.79),
+		Filhos: &PersistentSlice{NewPersistentString("Ana")},
+	}
+
+	PersistStruct("pcarlos", pCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	nCarlos := &pessoa{}
+	RestoreStruct("pcarlos", nCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *pCarlos.Nome != *nCarlos.Nome ||
+		*pCarlos.Altura != *nCarlos.Altura ||
+		len(*nCarlos.Filhos) != 1 {
+
+		t.Fail()
+	}
+}
+
+func TestHTTPStoragerDelete(t *testing.T) {
+	srv := httptest.NewServer(NewStoragerHandler(NewMemoryStorager()))
+	defer srv.Close()
+
+	storager := NewHTTPStorager(srv.URL + "/")
+	storager.Save([]byte("a"), []byte("1"))
+
+	if err := storager.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// the handler answers 501 when its Storager cannot delete
+	unsupported := httptest.NewServer(NewStoragerHandler(&testStorager{map[string][]byte{}}))
+	defer unsupported.Close()
+
+	if err := NewHTTPStorager(unsupported.URL + "/").Delete([]byte("a")); !errors.Is(err, ErrDeleteUnsupported) {
+		t.Fatalf("expected ErrDeleteUnsupported, got %v", err)
+	}
+}
+
+func TestHTTPStoragerScan(t *testing.T) {
+	srv := httptest.NewServer(NewStoragerHandler(NewMemoryStorager()))
+	defer srv.Close()
+
+	storager := NewHTTPStorager(srv.URL + "/")
+	for _, k := range []string{"a", "b", "c"} {
+		storager.Save([]byte(k), []byte(k))
+	}
+
+	keys := ""
+	err := storager.Scan(ScanOptions{}, func(k, v []byte) bool {
+		keys += string(k)
+		return len(keys) < 2
+	})
+
+	if err != nil || keys != "ab" {
+		t.Fatalf("expected the scan to stop after b, got %q, %v", keys, err)
+	}
+
+	unsupported := httptest.NewServer(NewStoragerHandler(&testStorager{map[string][]byte{}}))
+	defer unsupported.Close()
+
+	err = NewHTTPStorager(unsupported.URL).Scan(ScanOptions{}, func(k, v []byte) bool { return true })
+	if !errors.Is(err, ErrScanUnsupported) {
+		t.Fatalf("expected ErrScanUnsupported, got %v", err)
+	}
+}
+
+func TestHTTPStoragerBatch(t *testing.T) {
+	srv := httptest.NewServer(NewStoragerHandler(NewMemoryStorager()))
+	defer srv.Close()
+
+	storager := NewHTTPStorager(srv.URL)
+	storager.Save([]byte("a"), []byte("1"))
+
+	err := storager.Batch([]BatchOp{
+		{Key: []byte("a"), Delete: true},
+		{Key: []byte("b"), Value: []byte{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("b")); err != nil || len(dt) != 0 {
+		t.Fatalf("expected an empty b, got %q, %v", dt, err)
+	}
+
+	unsupported := httptest.NewServer(NewStoragerHandler(&testStorager{map[string][]byte{}}))
+	defer unsupported.Close()
+
+	err = NewHTTPStorager(unsupported.URL).Batch([]BatchOp{{Key: []byte("a")}})
+	if !errors.Is(err, ErrBatchUnsupported) {
+		t.Fatalf("expected ErrBatchUnsupported, got %v", err)
+	}
+}
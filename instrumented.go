@@ -0,0 +1,306 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var prefixNames = map[byte]string{
+	PersistentUndefinedPrefix: "undefined",
+	PersistentBoolPrefix:      "bool",
+	PersistentInt8Prefix:      "int8",
+	PersistentInt16Prefix:     "int16",
+	PersistentInt32Prefix:     "int32",
+	PersistentInt64Prefix:     "int64",
+	PersistentUint8Prefix:     "uint8",
+	PersistentUint16Prefix:    "uint16",
+	PersistentUint32Prefix:    "uint32",
+	PersistentUint64Prefix:    "uint64",
+	PersistentFloat32Prefix:   "float32",
+	PersistentFloat64Prefix:   "float64",
+	PersistentStringPrefix:    "string",
+	PersistentSlicePrefix:     "slice",
+	PersistentBytePrefix:      "byte",
+}
+
+// PrefixName names the value type of a key from its first byte, "other" for
+// keys that were not written by a Persistent type.
+func PrefixName(k []byte) string {
+	if len(k) > 0 {
+		if name, ok := prefixNames[k[0]]; ok {
+			return name
+		}
+	}
+
+	return "other"
+}
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histograms
+// of InstrumentedStorager.
+var LatencyBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// OpMetrics are the metrics of one operation on one value type. Latency
+// holds cumulative counts per LatencyBuckets bound, as Prometheus does.
+type OpMetrics struct {
+	Calls    uint64
+	Errors   uint64
+	NotFound uint64
+	Bytes    uint64
+
+	Latency      []uint64
+	LatencySum   float64
+	LatencyCount uint64
+}
+
+// InstrumentedStorager counts the Saves, Loads, Deletes, Scans and Batches of
+// the wrapped Storager, their errors, the bytes written and read and their
+// latency, broken down by value type. Metrics are exported with Publish
+// through expvar and with PrometheusHandler in the Prometheus text format.
+type InstrumentedStorager struct {
+	Storager Storager
+
+	lock    sync.Mutex
+	metrics map[string]map[string]*OpMetrics
+}
+
+func NewInstrumentedStorager(s Storager) *InstrumentedStorager {
+	return &InstrumentedStorager{
+		Storager: s,
+		metrics: map[string]map[string]*OpMetrics{
+			"save":   {},
+			"load":   {},
+			"delete": {},
+			"scan":   {},
+			"batch":  {},
+		},
+	}
+}
+
+func (is *InstrumentedStorager) observe(op string, k []byte, size int, err error, elapsed time.Duration) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	t := PrefixName(k)
+	m, ok := is.metrics[op][t]
+	if !ok {
+		m = &OpMetrics{Latency: make([]uint64, len(LatencyBuckets))}
+		is.metrics[op][t] = m
+	}
+
+	m.Calls++
+	m.Bytes += uint64(size)
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		m.NotFound++
+	case err != nil:
+		m.Errors++
+	}
+
+	seconds := elapsed.Seconds()
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			m.Latency[i]++
+		}
+	}
+	m.LatencySum += seconds
+	m.LatencyCount++
+}
+
+func (is *InstrumentedStorager) Save(k, v []byte) error {
+	return is.SaveContext(context.Background(), k, v)
+}
+
+func (is *InstrumentedStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	start := time.Now()
+	err := saveContext(ctx, is.Storager, k, v)
+
+	size := len(v)
+	if err != nil {
+		size = 0
+	}
+
+	is.observe("save", k, size, err, time.Since(start))
+	return err
+}
+
+func (is *InstrumentedStorager) Load(k []byte) ([]byte, error) {
+	return is.LoadContext(context.Background(), k)
+}
+
+func (is *InstrumentedStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	start := time.Now()
+	dt, err := loadContext(ctx, is.Storager, k)
+	is.observe("load", k, len(dt), err, time.Since(start))
+	return dt, err
+}
+
+func (is *InstrumentedStorager) wrapped() []Storager {
+	return []Storager{is.Storager}
+}
+
+func (is *InstrumentedStorager) Delete(k []byte) error {
+	return is.DeleteContext(context.Background(), k)
+}
+
+func (is *InstrumentedStorager) DeleteContext(ctx context.Context, k []byte) error {
+	start := time.Now()
+	err := DeleteContext(ctx, is.Storager, k)
+	is.observe("delete", k, 0, err, time.Since(start))
+	return err
+}
+
+// Snapshot returns a copy of the metrics by operation and value type.
+func (is *InstrumentedStorager) Snapshot() map[string]map[string]OpMetrics {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	snapshot := map[string]map[string]OpMetrics{}
+	for op, types := range is.metrics {
+		snapshot[op] = map[string]OpMetrics{}
+		for t, m := range types {
+			c := *m
+			c.Latency = append([]uint64(nil), m.Latency...)
+			snapshot[op][t] = c
+		}
+	}
+
+	return snapshot
+}
+
+// Publish exports the metrics as the expvar variable name. Like
+// expvar.Publish, it panics when name is already in use.
+func (is *InstrumentedStorager) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return is.Snapshot()
+	}))
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func (is *InstrumentedStorager) WritePrometheus(w io.Writer) error {
+	snapshot := is.Snapshot()
+
+	type series struct {
+		op, t string
+		m     OpMetrics
+	}
+
+	all := []series{}
+	for op, types := range snapshot {
+		for t, m := range types {
+			all = append(all, series{op, t, m})
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].op == all[j].op {
+			return all[i].t < all[j].t
+		}
+		return all[i].op < all[j].op
+	})
+
+	counters := []struct {
+		name, help string
+		value      func(OpMetrics) uint64
+	}{
+		{"persistent_storager_calls_total", "Calls to the storager.", func(m OpMetrics) uint64 { return m.Calls }},
+		{"persistent_storager_errors_total", "Failed calls, missing keys excluded.", func(m OpMetrics) uint64 { return m.Errors }},
+		{"persistent_storager_not_found_total", "Loads of missing keys.", func(m OpMetrics) uint64 { return m.NotFound }},
+		{"persistent_storager_bytes_total", "Bytes saved or loaded.", func(m OpMetrics) uint64 { return m.Bytes }},
+	}
+
+	ew := &errWriter{w: w}
+
+	for _, c := range counters {
+		ew.printf("# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, s := range all {
+			ew.printf("%s{op=%q,type=%q} %d\n", c.name, s.op, s.t, c.value(s.m))
+		}
+	}
+
+	name := "persistent_storager_duration_seconds"
+	ew.printf("# HELP %s Latency of the storager calls.\n# TYPE %s histogram\n", name, name)
+	for _, s := range all {
+		for i, bound := range LatencyBuckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			ew.printf("%s_bucket{op=%q,type=%q,le=%q} %d\n", name, s.op, s.t, le, s.m.Latency[i])
+		}
+		ew.printf("%s_bucket{op=%q,type=%q,le=\"+Inf\"} %d\n", name, s.op, s.t, s.m.LatencyCount)
+		ew.printf("%s_sum{op=%q,type=%q} %g\n", name, s.op, s.t, s.m.LatencySum)
+		ew.printf("%s_count{op=%q,type=%q} %d\n", name, s.op, s.t, s.m.LatencyCount)
+	}
+
+	return ew.err
+}
+
+// PrometheusHandler serves the metrics in the Prometheus text format.
+func (is *InstrumentedStorager) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		is.WritePrometheus(w)
+	})
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
+
+// Scan is observed under the value type of opts.Prefix, with the bytes of
+// every value visited.
+func (is *InstrumentedStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return is.ScanContext(context.Background(), opts, fn)
+}
+
+func (is *InstrumentedStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	size := 0
+
+	start := time.Now()
+	err := ScanContext(ctx, is.Storager, opts, func(k, v []byte) bool {
+		size += len(v)
+		return fn(k, v)
+	})
+	is.observe("scan", opts.Prefix, size, err, time.Since(start))
+	return err
+}
+
+// Batch is observed under the value type of the first key of ops, with the
+// bytes of every value saved.
+func (is *InstrumentedStorager) Batch(ops []BatchOp) error {
+	return is.BatchContext(context.Background(), ops)
+}
+
+func (is *InstrumentedStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	var k []byte
+	size := 0
+	for i, op := range ops {
+		if i == 0 {
+			k = op.Key
+		}
+
+		size += len(op.Value)
+	}
+
+	start := time.Now()
+	err := BatchContext(ctx, is.Storager, ops)
+	if err != nil {
+		size = 0
+	}
+
+	is.observe("batch", k, size, err, time.Since(start))
+	return err
+}
@@ -0,0 +1,85 @@
+package persistent
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentedStorager(t *testing.T) {
+	storager := NewInstrumentedStorager(NewMemoryStorager())
+
+	if err := NewPersistentInt64(-64).Persist(storager, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EmptyPersistentInt64().Restore(storager, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EmptyPersistentString().Restore(storager, []byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	storager.Save([]byte("raw"), []byte("abc"))
+
+	snapshot := storager.Snapshot()
+
+	save := snapshot["save"]["int64"]
+	if save.Calls != 1 || save.Bytes != 8 || save.LatencyCount != 1 {
+		t.Fatalf("unexpected int64 save metrics %+v", save)
+	}
+
+	load := snapshot["load"]["int64"]
+	if load.Calls != 1 || load.Bytes != 8 || load.Errors != 0 {
+		t.Fatalf("unexpected int64 load metrics %+v", load)
+	}
+
+	if m := snapshot["load"]["string"]; m.NotFound != 1 || m.Errors != 0 {
+		t.Fatalf("unexpected string load metrics %+v", m)
+	}
+
+	if m := snapshot["save"]["other"]; m.Bytes != 3 {
+		t.Fatalf("unexpected other save metrics %+v", m)
+	}
+
+	if last := save.Latency[len(save.Latency)-1]; last != 1 {
+		t.Fatalf("expected the last bucket to count the call, got %d", last)
+	}
+}
+
+func TestInstrumentedStoragerExport(t *testing.T) {
+	storager := NewInstrumentedStorager(NewMemoryStorager())
+	NewPersistentBool(true).Persist(storager, []byte("a"))
+
+	storager.Publish("persistent_test_storager")
+
+	metrics := map[string]map[string]OpMetrics{}
+	if err := json.Unmarshal([]byte(expvar.Get("persistent_test_storager").String()), &metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics["save"]["bool"].Calls != 1 {
+		t.Fatalf("unexpected expvar metrics %+v", metrics)
+	}
+
+	rec := httptest.NewRecorder()
+	storager.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE persistent_storager_calls_total counter",
+		`persistent_storager_calls_total{op="save",type="bool"} 1`,
+		`persistent_storager_bytes_total{op="save",type="bool"} 1`,
+		"# TYPE persistent_storager_duration_seconds histogram",
+		`persistent_storager_duration_seconds_bucket{op="save",type="bool",le="+Inf"} 1`,
+		`persistent_storager_duration_seconds_count{op="save",type="bool"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %s in\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package persistent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	logRecordValue = byte(iota)
+	logRecordTombstone
+
+	// logRecordBatch has no key, its value holds the records of a batch,
+	// which the checksum of the batch covers.
+	logRecordBatch
+)
+
+// ErrLogCorrupt is returned when opening a log with a damaged record followed
+// by good ones. The file is left untouched.
+var ErrLogCorrupt = errors.New("log is corrupt")
+
+// crc32 | kind | key length | value length
+const logHeaderSize = 4 + 1 + 4 + 4
+
+type logEntry struct {
+	offset int64
+	size   uint32
+}
+
+// LogStorager is an append only, single file Storager in the style of
+// Bitcask. Every Save appends a record to the log and an in-memory key
+// directory points Load at the latest record of each key. The directory is
+// rebuilt when the file is opened, dropping a record torn by a crash at the
+// end of the log, and Compact rewrites the log keeping only live records.
+type LogStorager struct {
+	// Sync makes every Save fsync the log before returning.
+	Sync bool
+
+	// CompactRatio starts a background compaction after a Save once the
+	// superseded bytes exceed this fraction of the log. Zero disables it.
+	CompactRatio float64
+
+	// CompactMinSize is the smallest log, in bytes, that is compacted
+	// automatically.
+	CompactMinSize int64
+
+	path string
+
+	lock       sync.RWMutex
+	file       *os.File
+	size       int64
+	garbage    int64
+	keydir     map[string]logEntry
+	compacting bool
+	closing    bool
+	wg         sync.WaitGroup
+}
+
+func OpenLogStorager(path string) (*LogStorager, error) {
+	ls := &LogStorager{
+		path:           path,
+		CompactMinSize: 1 << 20,
+	}
+
+	if err := ls.open(); err != nil {
+		return nil, err
+	}
+
+	return ls, nil
+}
+
+func (ls *LogStorager) open() error {
+	file, err := os.OpenFile(ls.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	keydir, size, garbage, err := readLog(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// drop a record torn by a crash in the middle of a write
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+
+	ls.file = file
+	ls.size = size
+	ls.garbage = garbage
+	ls.keydir = keydir
+	return nil
+}
+
+func readLog(file *os.File) (map[string]logEntry, int64, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	keydir := map[string]logEntry{}
+	var offset, garbage int64
+
+	header := make([]byte, logHeaderSize)
+	for {
+		_, err := file.ReadAt(header, offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		kind := header[4]
+		kLen := binary.LittleEndian.Uint32(header[5:])
+		vLen := binary.LittleEndian.Uint32(header[9:])
+
+		if offset+logHeaderSize+int64(kLen)+int64(vLen) > info.Size() {
+			// a record running past the end is torn only when no good
+			// records follow it, otherwise its lengths were damaged
+			damaged, err := logRecordsAfter(file, offset, info.Size())
+			if err != nil {
+				return nil, 0, 0, err
+			}
+
+			if damaged {
+				return nil, 0, 0, fmt.Errorf("%w: bad length at offset %d of %s", ErrLogCorrupt, offset, file.Name())
+			}
+			break
+		}
+
+		body := make([]byte, int64(kLen)+int64(vLen))
+		if _, err := file.ReadAt(body, offset+logHeaderSize); err != nil {
+			return nil, 0, 0, err
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header) {
+			// only the last record can be torn, a bad one before it was
+			// damaged after being written
+			if offset+logHeaderSize+int64(len(body)) < info.Size() {
+				return nil, 0, 0, fmt.Errorf("%w: bad checksum at offset %d of %s", ErrLogCorrupt, offset, file.Name())
+			}
+			break
+		}
+
+		if kind == logRecordBatch {
+			batchGarbage, err := applyLogBatch(keydir, body, offset+logHeaderSize)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+
+			garbage += logHeaderSize + batchGarbage
+		} else {
+			recordGarbage, err := applyLogRecord(keydir, kind, body[:kLen], offset, vLen)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+
+			garbage += recordGarbage
+		}
+
+		offset += logHeaderSize + int64(len(body))
+	}
+
+	return keydir, offset, garbage, nil
+}
+
+// logRecordsAfter reports whether records with good checksums run from
+// somewhere after offset to the end of the log of the given size.
+func logRecordsAfter(file *os.File, offset, size int64) (bool, error) {
+	window := make([]byte, 64<<10)
+	var start, end int64
+
+	for pos := offset + 1; pos+logHeaderSize <= size; pos++ {
+		if pos+logHeaderSize > end {
+			n, err := file.ReadAt(window, pos)
+			if err != nil && err != io.EOF {
+				return false, err
+			}
+			start, end = pos, pos+int64(n)
+		}
+
+		// skip the positions that cannot start a record without reading
+		header := window[pos-start : pos-start+logHeaderSize]
+		bodyLen := int64(binary.LittleEndian.Uint32(header[5:])) + int64(binary.LittleEndian.Uint32(header[9:]))
+		if header[4] > logRecordBatch || pos+logHeaderSize+bodyLen > size {
+			continue
+		}
+
+		chained, err := logRecordsFrom(file, pos, size)
+		if err != nil || chained {
+			return chained, err
+		}
+	}
+
+	return false, nil
+}
+
+// logRecordsFrom reports whether records with good checksums run from offset
+// to the end of the log.
+func logRecordsFrom(file *os.File, offset, size int64) (bool, error) {
+	header := make([]byte, logHeaderSize)
+
+	for offset < size {
+		if offset+logHeaderSize > size {
+			return false, nil
+		}
+
+		if _, err := file.ReadAt(header, offset); err != nil {
+			return false, err
+		}
+
+		bodyLen := int64(binary.LittleEndian.Uint32(header[5:])) + int64(binary.LittleEndian.Uint32(header[9:]))
+		if header[4] > logRecordBatch || offset+logHeaderSize+bodyLen > size {
+			return false, nil
+		}
+
+		body := make([]byte, bodyLen)
+		if _, err := file.ReadAt(body, offset+logHeaderSize); err != nil {
+			return false, err
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header) {
+			return false, nil
+		}
+
+		offset += logHeaderSize + bodyLen
+	}
+
+	return true, nil
+}
+
+// applyLogRecord updates keydir with the record at offset and returns how
+// many bytes of the log it turned into garbage.
+func applyLogRecord(keydir map[string]logEntry, kind byte, k []byte, offset int64, vLen uint32) (int64, error) {
+	garbage := int64(0)
+	if old, ok := keydir[string(k)]; ok {
+		garbage += logHeaderSize + int64(len(k)) + int64(old.size)
+	}
+
+	switch kind {
+	case logRecordValue:
+		keydir[string(k)] = logEntry{
+			offset: offset + logHeaderSize + int64(len(k)),
+			size:   vLen,
+		}
+	case logRecordTombstone:
+		delete(keydir, string(k))
+		garbage += logHeaderSize + int64(len(k)) + int64(vLen)
+	default:
+		return 0, fmt.Errorf("unknown record kind %d at offset %d", kind, offset)
+	}
+
+	return garbage, nil
+}
+
+// applyLogBatch applies the records of a batch whose value starts at offset.
+func applyLogBatch(keydir map[string]logEntry, batch []byte, offset int64) (int64, error) {
+	garbage := int64(0)
+
+	for pos := 0; pos < len(batch); {
+		if len(batch)-pos < logHeaderSize {
+			return 0, fmt.Errorf("malformed batch at offset %d", offset)
+		}
+
+		header := batch[pos : pos+logHeaderSize]
+		kLen := binary.LittleEndian.Uint32(header[5:])
+		vLen := binary.LittleEndian.Uint32(header[9:])
+
+		end := int64(pos) + logHeaderSize + int64(kLen) + int64(vLen)
+		if end > int64(len(batch)) {
+			return 0, fmt.Errorf("malformed batch at offset %d", offset)
+		}
+
+		k := batch[pos+logHeaderSize : pos+logHeaderSize+int(kLen)]
+		recordGarbage, err := applyLogRecord(keydir, header[4], k, offset+int64(pos), vLen)
+		if err != nil {
+			return 0, err
+		}
+
+		garbage += recordGarbage
+		pos = int(end)
+	}
+
+	return garbage, nil
+}
+
+func encodeLogRecord(kind byte, k, v []byte) []byte {
+	record := make([]byte, logHeaderSize+len(k)+len(v))
+	record[4] = kind
+	binary.LittleEndian.PutUint32(record[5:], uint32(len(k)))
+	binary.LittleEndian.PutUint32(record[9:], uint32(len(v)))
+	copy(record[logHeaderSize:], k)
+	copy(record[logHeaderSize+len(k):], v)
+	binary.LittleEndian.PutUint32(record, crc32.ChecksumIEEE(record[4:]))
+
+	return record
+}
+
+func (ls *LogStorager) Save(k, v []byte) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if ls.file == nil {
+		return os.ErrClosed
+	}
+
+	return ls.save(k, v)
+}
+
+// save must be called with the lock held.
+func (ls *LogStorager) save(k, v []byte) error {
+	record := encodeLogRecord(logRecordValue, k, v)
+
+	if _, err := ls.file.WriteAt(record, ls.size); err != nil {
+		return err
+	}
+
+	if ls.Sync {
+		if err := ls.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if old, ok := ls.keydir[string(k)]; ok {
+		ls.garbage += logHeaderSize + int64(len(k)) + int64(old.size)
+	}
+
+	ls.keydir[string(k)] = logEntry{
+		offset: ls.size + logHeaderSize + int64(len(k)),
+		size:   uint32(len(v)),
+	}
+	ls.size += int64(len(record))
+
+	ls.maybeCompact()
+	return nil
+}
+
+func (ls *LogStorager) Load(k []byte) ([]byte, error) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	if ls.file == nil {
+		return nil, os.ErrClosed
+	}
+
+	return ls.load(k)
+}
+
+// load must be called with the lock held.
+func (ls *LogStorager) load(k []byte) ([]byte, error) {
+	entry, ok := ls.keydir[string(k)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	dt := make([]byte, entry.size)
+	if _, err := ls.file.ReadAt(dt, entry.offset); err != nil {
+		return nil, err
+	}
+
+	return dt, nil
+}
+
+// Delete appends a tombstone record for k, which Compact drops along with
+// the value it supersedes.
+func (ls *LogStorager) Delete(k []byte) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if ls.file == nil {
+		return os.ErrClosed
+	}
+
+	return ls.delete(k)
+}
+
+// delete must be called with the lock held.
+func (ls *LogStorager) delete(k []byte) error {
+	record := encodeLogRecord(logRecordTombstone, k, nil)
+
+	old, ok := ls.keydir[string(k)]
+	if !ok {
+		return nil
+	}
+
+	if _, err := ls.file.WriteAt(record, ls.size); err != nil {
+		return err
+	}
+
+	if ls.Sync {
+		if err := ls.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	delete(ls.keydir, string(k))
+	ls.garbage += logHeaderSize + int64(len(k)) + int64(old.size) + int64(len(record))
+	ls.size += int64(len(record))
+
+	ls.maybeCompact()
+	return nil
+}
+
+func (ls *LogStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	ls.lock.RLock()
+	if ls.file == nil {
+		ls.lock.RUnlock()
+		return os.ErrClosed
+	}
+
+	keys := make([][]byte, 0, len(ls.keydir))
+	for k := range ls.keydir {
+		keys = append(keys, []byte(k))
+	}
+	ls.lock.RUnlock()
+
+	return scanKeys(keys, opts, ls.Load, fn)
+}
+
+func (ls *LogStorager) CompareAndSwap(k, old, new []byte) (bool, error) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if ls.file == nil {
+		return false, os.ErrClosed
+	}
+
+	cur, err := ls.load(k)
+	if errors.Is(err, ErrNotFound) {
+		cur, err = nil, nil
+	}
+
+	if err != nil || !casMatches(cur, old) {
+		return false, err
+	}
+
+	if new == nil {
+		return true, ls.delete(k)
+	}
+
+	return true, ls.save(k, new)
+}
+
+// Batch appends the ops as a single record, so a crash in the middle of the
+// write loses the whole batch.
+func (ls *LogStorager) Batch(ops []BatchOp) error {
+	batch := new(bytes.Buffer)
+	for _, op := range ops {
+		if op.Delete {
+			batch.Write(encodeLogRecord(logRecordTombstone, op.Key, nil))
+		} else {
+			batch.Write(encodeLogRecord(logRecordValue, op.Key, op.Value))
+		}
+	}
+
+	record := encodeLogRecord(logRecordBatch, nil, batch.Bytes())
+
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if ls.file == nil {
+		return os.ErrClosed
+	}
+
+	if _, err := ls.file.WriteAt(record, ls.size); err != nil {
+		return err
+	}
+
+	if ls.Sync {
+		if err := ls.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	garbage, err := applyLogBatch(ls.keydir, batch.Bytes(), ls.size+logHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	ls.garbage += logHeaderSize + garbage
+	ls.size += int64(len(record))
+
+	ls.maybeCompact()
+	return nil
+}
+
+// maybeCompact must be called with the write lock held.
+func (ls *LogStorager) maybeCompact() {
+	if ls.CompactRatio <= 0 || ls.compacting || ls.closing || ls.size < ls.CompactMinSize {
+		return
+	}
+
+	if float64(ls.garbage) < float64(ls.size)*ls.CompactRatio {
+		return
+	}
+
+	ls.compacting = true
+	ls.wg.Add(1)
+	go func() {
+		defer ls.wg.Done()
+		ls.Compact()
+	}()
+}
+
+// Compact rewrites the log with only the latest record of every key. Saves
+// and Loads wait while it runs.
+func (ls *LogStorager) Compact() error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	ls.compacting = false
+
+	if ls.file == nil {
+		return os.ErrClosed
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ls.path), filepath.Base(ls.path)+".compact-")
+	if err != nil {
+		return err
+	}
+
+	// the old log stays open until the new one replaced it
+	keydir, size, garbage, err := ls.writeCompacted(tmp)
+	if err == nil {
+		err = os.Rename(tmp.Name(), ls.path)
+	}
+
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	ls.file.Close()
+	ls.file = tmp
+	ls.size = size
+	ls.garbage = garbage
+	ls.keydir = keydir
+
+	return syncDir(filepath.Dir(ls.path))
+}
+
+// writeCompacted writes the live records to file and reads them back.
+func (ls *LogStorager) writeCompacted(file *os.File) (map[string]logEntry, int64, int64, error) {
+	if err := ls.copyLive(file); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := file.Sync(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return readLog(file)
+}
+
+func (ls *LogStorager) copyLive(file io.Writer) error {
+	w := bufio.NewWriter(file)
+
+	for k, entry := range ls.keydir {
+		v := make([]byte, entry.size)
+		if _, err := ls.file.ReadAt(v, entry.offset); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(encodeLogRecord(logRecordValue, []byte(k), v)); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// Size reports the size of the log and how many of its bytes belong to
+// superseded records.
+func (ls *LogStorager) Size() (size, garbage int64) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	return ls.size, ls.garbage
+}
+
+// Close waits for a background compaction to finish, and keeps new ones from
+// starting meanwhile.
+func (ls *LogStorager) Close() error {
+	ls.lock.Lock()
+	if ls.file == nil || ls.closing {
+		ls.lock.Unlock()
+		return os.ErrClosed
+	}
+	ls.closing = true
+	ls.lock.Unlock()
+
+	ls.wg.Wait()
+
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	err := ls.file.Close()
+	ls.file = nil
+	return err
+}
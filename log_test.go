@@ -0,0 +1,432 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogStorager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	storager, err := OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := storager.Save([]byte("test"), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storager.Save([]byte{PersistentBoolPrefix}, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := storager.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storager, err = OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	dt, err := storager.Load([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dt, []byte{9}) {
+		t.Fatalf("expected 9, got %v", dt)
+	}
+
+	dt, err = storager.Load([]byte{PersistentBoolPrefix})
+	if err != nil || len(dt) != 0 {
+		t.Fatalf("expected empty value, got %v, %v", dt, err)
+	}
+}
+
+func TestLogStoragerTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	storager, err := OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storager.Save([]byte("a"), []byte("1"))
+	storager.Save([]byte("b"), []byte("2"))
+	size, _ := storager.Size()
+	storager.Close()
+
+	if err := os.Truncate(path, size-1); err != nil {
+		t.Fatal(err)
+	}
+
+	storager, err = OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	if _, err := storager.Load([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected torn record to be dropped, got %v", err)
+	}
+
+	if err := storager.Save([]byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if dt, err := storager.Load([]byte("c")); err != nil || string(dt) != "3" {
+		t.Fatalf("expected 3, got %q, %v", dt, err)
+	}
+}
+
+func TestLogStoragerCorruptRecord(t *testing.T) {
+	record := int64(logHeaderSize + 2)
+
+	damages := map[string]int64{
+		"value of a":        logHeaderSize + 1,
+		"value length of a": 9,
+		"value length of b": record + 9,
+		"key length of b":   record + 5,
+	}
+
+	for name, at := range damages {
+		path := filepath.Join(t.TempDir(), "test.log")
+
+		storager, err := OpenLogStorager(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		storager.Save([]byte("a"), []byte("1"))
+		storager.Save([]byte("b"), []byte("2"))
+		storager.Save([]byte("c"), []byte("3"))
+		size, _ := storager.Size()
+		storager.Close()
+
+		dt, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dt[at] ^= 0xff
+		if err := os.WriteFile(path, dt, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := OpenLogStorager(path); !errors.Is(err, ErrLogCorrupt) {
+			t.Fatalf("%s: expected ErrLogCorrupt, got %v", name, err)
+		}
+
+		if info, err := os.Stat(path); err != nil || info.Size() != size {
+			t.Fatalf("%s: expected the log to be left untouched, got %v", name, err)
+		}
+	}
+}
+
+func TestLogStoragerCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	storager, err := OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("k%d", i%10))
+		if err := storager.Save(k, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, garbage := storager.Size()
+	if garbage == 0 {
+		t.Fatal("expected superseded records")
+	}
+
+	if err := storager.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, garbage := storager.Size()
+	if garbage != 0 || after >= before {
+		t.Fatalf("compaction did not shrink the log: %d -> %d", before, after)
+	}
+
+	for i := 90; i < 100; i++ {
+		dt, err := storager.Load([]byte(fmt.Sprintf("k%d", i%10)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(dt) != fmt.Sprint(i) {
+			t.Fatalf("expected %d, got %s", i, dt)
+		}
+	}
+}
+
+func TestLogStoragerCompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	storager, err := OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	storager.Save([]byte("a"), []byte("1"))
+
+	// a non empty directory in place of the log makes the rename fail
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Join(path, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storager.Compact(); err == nil {
+		t.Fatal("expected the compaction to fail")
+	}
+
+	if err := storager.Save([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("expected the storager to stay open, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "1" {
+		t.Fatalf("expected 1, got %q, %v", dt, err)
+	}
+}
+
+func TestLogStoragerAutoCompact(t *testing.T) {
+	storager, err := OpenLogStorager(filepath.Join(t.TempDir(), "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storager.CompactRatio = 0.5
+	storager.CompactMinSize = 1024
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				k := []byte(fmt.Sprintf("k%d", g))
+				if err := storager.Save(k, []byte(fmt.Sprint(i))); err != nil {
+					t.Error(err)
+				}
+				if _, err := storager.Load(k); err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := storager.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	size, _ := storager.Size()
+	if size >= 8*200*logHeaderSize {
+		t.Fatalf("log was never compacted: %d bytes", size)
+	}
+}
+
+func TestLogStoragerCloseWhileCompacting(t *testing.T) {
+	storager, err := OpenLogStorager(filepath.Join(t.TempDir(), "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storager.CompactRatio = 0.5
+	storager.CompactMinSize = 1024
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				err := storager.Save([]byte(fmt.Sprintf("k%d", g)), []byte(fmt.Sprint(i)))
+				if errors.Is(err, os.ErrClosed) {
+					return
+				}
+
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := storager.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if err := storager.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+}
+
+func TestLogStoragerSlice(t *testing.T) {
+	storager, err := OpenLogStorager(filepath.Join(t.TempDir(), "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	testSlice := PersistentSlice([]Persistent{
+		NewPersistentFloat64(1.5),
+		NewPersistentFloat64(-2),
+	})
+
+	if err := testSlice.Persist(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	nTest := PersistentSlice([]Persistent{})
+	if err := nTest.Restore(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nTest) != 2 || *nTest[1].(*PersistentFloat64) != -2 {
+		t.Fail()
+	}
+}
+
+func TestLogStoragerDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	storager, err := OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storager.Save([]byte("a"), []byte("1"))
+	storager.Save([]byte("b"), []byte("2"))
+
+	if err := storager.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	size, _ := storager.Size()
+	if err := storager.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := storager.Size(); again != size {
+		t.Fatal("deleting a missing key appended a record")
+	}
+
+	storager.Close()
+
+	storager, err = OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the tombstone to survive a reopen, got %v", err)
+	}
+
+	if err := storager.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	size, garbage := storager.Size()
+	if garbage != 0 || size != logHeaderSize+2 {
+		t.Fatalf("expected only b to be left, got %d bytes with %d of garbage", size, garbage)
+	}
+}
+
+func TestLogStoragerBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	storager, err := OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storager.Save([]byte("a"), []byte("1"))
+
+	err = storager.Batch([]BatchOp{
+		{Key: []byte("a"), Delete: true},
+		{Key: []byte("b"), Value: []byte("2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, _ := storager.Size()
+
+	err = storager.Batch([]BatchOp{
+		{Key: []byte("b"), Value: []byte("3")},
+		{Key: []byte("c"), Value: []byte("4")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dt, err := storager.Load([]byte("c")); err != nil || string(dt) != "4" {
+		t.Fatalf("expected 4, got %q, %v", dt, err)
+	}
+
+	storager.Close()
+
+	// tear the last byte of the second batch
+	full, _ := os.Stat(path)
+	if err := os.Truncate(path, full.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	storager, err = OpenLogStorager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storager.Close()
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected the torn batch to be dropped whole, got %q, %v", dt, err)
+	}
+
+	if _, err := storager.Load([]byte("c")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the torn batch to be dropped whole, got %v", err)
+	}
+
+	if reopened, _ := storager.Size(); reopened != size {
+		t.Fatalf("expected the log to end after the first batch, got %d bytes instead of %d", reopened, size)
+	}
+
+	if err := storager.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	size, garbage := storager.Size()
+	if garbage != 0 || size != logHeaderSize+2 {
+		t.Fatalf("expected only b to be left, got %d bytes with %d of garbage", size, garbage)
+	}
+}
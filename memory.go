@@ -0,0 +1,147 @@
+package persistent
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+)
+
+// ErrNotFound is returned by the bundled storagers when a key has no value.
+var ErrNotFound = errors.New("value is not stored")
+
+const memoryShardCount = 32
+
+type memoryShard struct {
+	lock sync.RWMutex
+	db   map[string][]byte
+}
+
+// MemoryStorager is a thread-safe in-memory Storager. Values are copied on
+// Save and on Load, so callers never share memory with the store.
+type MemoryStorager struct {
+	shards [memoryShardCount]*memoryShard
+}
+
+func NewMemoryStorager() *MemoryStorager {
+	ms := new(MemoryStorager)
+	for i := range ms.shards {
+		ms.shards[i] = &memoryShard{db: map[string][]byte{}}
+	}
+
+	return ms
+}
+
+func memoryShardIndex(k []byte) int {
+	h := fnv.New32a()
+	h.Write(k)
+	return int(h.Sum32() % memoryShardCount)
+}
+
+func (ms *MemoryStorager) shard(k []byte) *memoryShard {
+	return ms.shards[memoryShardIndex(k)]
+}
+
+func (ms *MemoryStorager) Save(k, v []byte) error {
+	val := make([]byte, len(v))
+	copy(val, v)
+
+	sh := ms.shard(k)
+	sh.lock.Lock()
+	sh.db[string(k)] = val
+	sh.lock.Unlock()
+
+	return nil
+}
+
+func (ms *MemoryStorager) Load(k []byte) ([]byte, error) {
+	sh := ms.shard(k)
+	sh.lock.RLock()
+	val, ok := sh.db[string(k)]
+	sh.lock.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	dt := make([]byte, len(val))
+	copy(dt, val)
+	return dt, nil
+}
+
+func (ms *MemoryStorager) Delete(k []byte) error {
+	sh := ms.shard(k)
+	sh.lock.Lock()
+	delete(sh.db, string(k))
+	sh.lock.Unlock()
+
+	return nil
+}
+
+func (ms *MemoryStorager) CompareAndSwap(k, old, new []byte) (bool, error) {
+	sh := ms.shard(k)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+
+	if !casMatches(sh.db[string(k)], old) {
+		return false, nil
+	}
+
+	if new == nil {
+		delete(sh.db, string(k))
+	} else {
+		sh.db[string(k)] = append([]byte{}, new...)
+	}
+
+	return true, nil
+}
+
+// Batch holds the locks of every shard written while it applies ops.
+func (ms *MemoryStorager) Batch(ops []BatchOp) error {
+	locked := [memoryShardCount]bool{}
+	for _, op := range ops {
+		locked[memoryShardIndex(op.Key)] = true
+	}
+
+	// shards are locked in order, so concurrent batches cannot deadlock
+	for i, sh := range ms.shards {
+		if locked[i] {
+			sh.lock.Lock()
+			defer sh.lock.Unlock()
+		}
+	}
+
+	for _, op := range ops {
+		sh := ms.shard(op.Key)
+		if op.Delete {
+			delete(sh.db, string(op.Key))
+		} else {
+			sh.db[string(op.Key)] = append([]byte{}, op.Value...)
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorager) Len() int {
+	n := 0
+	for _, sh := range ms.shards {
+		sh.lock.RLock()
+		n += len(sh.db)
+		sh.lock.RUnlock()
+	}
+
+	return n
+}
+
+func (ms *MemoryStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	keys := [][]byte{}
+	for _, sh := range ms.shards {
+		sh.lock.RLock()
+		for k := range sh.db {
+			keys = append(keys, []byte(k))
+		}
+		sh.lock.RUnlock()
+	}
+
+	return scanKeys(keys, opts, ms.Load, fn)
+}
@@ -0,0 +1,91 @@
+package persistent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStorager(t *testing.T) {
+	storager := NewMemoryStorager()
+
+	val := []byte("value")
+	if err := storager.Save([]byte("test"), val); err != nil {
+		t.Fatal(err)
+	}
+
+	val[0] = 'X'
+
+	dt, err := storager.Load([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dt, []byte("value")) {
+		t.Fatalf("stored value was mutated: %q", dt)
+	}
+
+	dt[0] = 'X'
+
+	dt, err = storager.Load([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(dt, []byte("value")) {
+		t.Fatalf("loaded value shares memory with the store: %q", dt)
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryStoragerConcurrent(t *testing.T) {
+	storager := NewMemoryStorager()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := []byte(fmt.Sprintf("k%d", i))
+			if err := storager.Save(k, k); err != nil {
+				t.Error(err)
+			}
+			if _, err := storager.Load(k); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if storager.Len() != 64 {
+		t.Fatalf("expected 64 keys, got %d", storager.Len())
+	}
+}
+
+func TestMemoryStoragerSlice(t *testing.T) {
+	storager := NewMemoryStorager()
+
+	testSlice := PersistentSlice([]Persistent{
+		NewPersistentString("a"),
+		NewPersistentString("b"),
+		NewPersistentString("c"),
+	})
+
+	if err := testSlice.Persist(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	nTest := PersistentSlice([]Persistent{})
+	if err := nTest.Restore(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nTest) != 3 || *nTest[1].(*PersistentString) != "b" {
+		t.Fail()
+	}
+}
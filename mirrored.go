@@ -0,0 +1,321 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuorum is returned when too few replicas answered to reach the quorum.
+var ErrQuorum = errors.New("quorum not reached")
+
+// mirrorTombstone flags the version of a deleted key. Versions are clock
+// readings in nanoseconds and never reach this bit.
+const mirrorTombstone = 1 << 63
+
+type mirrorReply struct {
+	replica int
+	version uint64
+	deleted bool
+	value   []byte
+	err     error
+}
+
+// MirroredStorager replicates every key on several Storagers. A Save
+// succeeds once WriteQuorum replicas stored it and a Load returns once
+// ReadQuorum replicas answered, picking the most recent value and writing it
+// back to the replicas that answered with an older one.
+//
+// Values are stored with an 8 byte version taken from the clock, so writers
+// in different processes need reasonably synchronized clocks. Delete saves a
+// versioned tombstone, so a replica that missed it cannot bring the value
+// back.
+type MirroredStorager struct {
+	WriteQuorum int
+	ReadQuorum  int
+
+	replicas []Storager
+
+	lock    sync.Mutex
+	version uint64
+}
+
+func NewMirroredStorager(writeQuorum, readQuorum int, replicas ...Storager) *MirroredStorager {
+	return &MirroredStorager{
+		WriteQuorum: writeQuorum,
+		ReadQuorum:  readQuorum,
+		replicas:    replicas,
+	}
+}
+
+func (ms *MirroredStorager) nextVersion() uint64 {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	now := uint64(time.Now().UnixNano())
+	if now <= ms.version {
+		now = ms.version + 1
+	}
+
+	ms.version = now
+	return now
+}
+
+func encodeMirrorValue(version uint64, v []byte) []byte {
+	dt := make([]byte, 8+len(v))
+	binary.BigEndian.PutUint64(dt, version)
+	copy(dt[8:], v)
+	return dt
+}
+
+func (ms *MirroredStorager) Save(k, v []byte) error {
+	return ms.SaveContext(context.Background(), k, v)
+}
+
+func (ms *MirroredStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	dt := encodeMirrorValue(ms.nextVersion(), v)
+	return ms.write(ctx, fmt.Sprintf("%q", k), func(r Storager) error {
+		return saveContext(ctx, r, k, dt)
+	})
+}
+
+func (ms *MirroredStorager) Delete(k []byte) error {
+	return ms.DeleteContext(context.Background(), k)
+}
+
+func (ms *MirroredStorager) DeleteContext(ctx context.Context, k []byte) error {
+	dt := encodeMirrorValue(ms.nextVersion()|mirrorTombstone, nil)
+	return ms.write(ctx, fmt.Sprintf("%q", k), func(r Storager) error {
+		return saveContext(ctx, r, k, dt)
+	})
+}
+
+// Batch writes ops, deletes as tombstones, all with the same version, as a
+// batch on every replica. It is atomic on each replica but not across them:
+// a replica that failed the batch is repaired key by key by later Loads.
+func (ms *MirroredStorager) Batch(ops []BatchOp) error {
+	return ms.BatchContext(context.Background(), ops)
+}
+
+func (ms *MirroredStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	version := ms.nextVersion()
+
+	versioned, _ := mapBatch(ops, func(op BatchOp) (BatchOp, error) {
+		if op.Delete {
+			return BatchOp{Key: op.Key, Value: encodeMirrorValue(version|mirrorTombstone, nil)}, nil
+		}
+
+		return BatchOp{Key: op.Key, Value: encodeMirrorValue(version, op.Value)}, nil
+	})
+
+	return ms.write(ctx, fmt.Sprintf("a batch of %d ops", len(ops)), func(r Storager) error {
+		return BatchContext(ctx, r, versioned)
+	})
+}
+
+// write runs fn on every replica and succeeds once WriteQuorum of them did.
+func (ms *MirroredStorager) write(ctx context.Context, what string, fn func(r Storager) error) error {
+	errs := make(chan error, len(ms.replicas))
+	for _, r := range ms.replicas {
+		go func(r Storager) {
+			errs <- fn(r)
+		}(r)
+	}
+
+	acks, failures := 0, []error{}
+	for range ms.replicas {
+		err := <-errs
+		if err == nil {
+			acks++
+		} else {
+			failures = append(failures, err)
+		}
+
+		if acks >= ms.WriteQuorum {
+			return nil
+		}
+
+		if len(failures) > len(ms.replicas)-ms.WriteQuorum {
+			break
+		}
+	}
+
+	for _, err := range failures {
+		// the caller can still fall back to writing the ops one by one
+		if errors.Is(err, ErrBatchUnsupported) {
+			return ErrBatchUnsupported
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("writing %s on %d of %d replicas: %w: %v", what, acks, ms.WriteQuorum, ErrQuorum, failures)
+}
+
+func (ms *MirroredStorager) Load(k []byte) ([]byte, error) {
+	return ms.LoadContext(context.Background(), k)
+}
+
+func (ms *MirroredStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	replies := make(chan mirrorReply, len(ms.replicas))
+	for i, r := range ms.replicas {
+		go func(i int, r Storager) {
+			reply := mirrorReply{replica: i}
+
+			dt, err := loadContext(ctx, r, k)
+			switch {
+			case err != nil:
+				reply.err = err
+			case len(dt) < 8:
+				reply.err = fmt.Errorf("%q is not a mirrored value", k)
+			default:
+				reply.version = binary.BigEndian.Uint64(dt) &^ mirrorTombstone
+				reply.deleted = dt[0]&0x80 != 0
+				reply.value = dt[8:]
+			}
+
+			replies <- reply
+		}(i, r)
+	}
+
+	answered, failures := []mirrorReply{}, []error{}
+	for range ms.replicas {
+		reply := <-replies
+		if reply.err == nil || errors.Is(reply.err, ErrNotFound) {
+			answered = append(answered, reply)
+		} else {
+			failures = append(failures, reply.err)
+		}
+
+		if len(answered) >= ms.ReadQuorum || len(failures) > len(ms.replicas)-ms.ReadQuorum {
+			break
+		}
+	}
+
+	if err := ctx.Err(); err != nil && len(answered) < ms.ReadQuorum {
+		return nil, err
+	}
+
+	if len(answered) < ms.ReadQuorum {
+		return nil, fmt.Errorf("loading %q from %d of %d replicas: %w: %v", k, len(answered), ms.ReadQuorum, ErrQuorum, failures)
+	}
+
+	latest := answered[0]
+	for _, reply := range answered[1:] {
+		if reply.err == nil && (latest.err != nil || reply.version > latest.version) {
+			latest = reply
+		}
+	}
+
+	if latest.err != nil {
+		return nil, ErrNotFound
+	}
+
+	stale := []Storager{}
+	for _, reply := range answered {
+		if reply.err != nil && latest.deleted {
+			continue
+		}
+
+		if reply.err != nil || reply.version != latest.version || !bytes.Equal(reply.value, latest.value) {
+			stale = append(stale, ms.replicas[reply.replica])
+		}
+	}
+
+	version := latest.version
+	if latest.deleted {
+		version |= mirrorTombstone
+	}
+
+	if len(stale) > 0 {
+		// read repair is best effort, the value was already read
+		ms.repair(ctx, k, encodeMirrorValue(version, latest.value), stale)
+	}
+
+	if latest.deleted {
+		return nil, ErrNotFound
+	}
+
+	return latest.value, nil
+}
+
+func (ms *MirroredStorager) repair(ctx context.Context, k, dt []byte, stale []Storager) {
+	wg := new(sync.WaitGroup)
+	for _, r := range stale {
+		wg.Add(1)
+		go func(r Storager) {
+			defer wg.Done()
+			saveContext(ctx, r, k, dt)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// Scan lists the keys of every replica, and needs ReadQuorum of them to
+// answer. Every key is then read as Load does, so deleted keys are skipped
+// and stale replicas repaired.
+func (ms *MirroredStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return ms.ScanContext(context.Background(), opts, fn)
+}
+
+func (ms *MirroredStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	listing := opts
+	listing.Limit = 0
+	listing.KeysOnly = true
+
+	keys := map[string]bool{}
+	answered, failures := 0, []error{}
+
+	for _, r := range ms.replicas {
+		replicaKeys := []string{}
+		err := ScanContext(ctx, r, listing, func(k, v []byte) bool {
+			replicaKeys = append(replicaKeys, string(k))
+			return true
+		})
+
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+
+		answered++
+		for _, k := range replicaKeys {
+			keys[k] = true
+		}
+	}
+
+	if err := ctx.Err(); err != nil && answered < ms.ReadQuorum {
+		return err
+	}
+
+	if answered < ms.ReadQuorum {
+		return fmt.Errorf("scanning %d of %d replicas: %w: %v", answered, ms.ReadQuorum, ErrQuorum, failures)
+	}
+
+	list := make([][]byte, 0, len(keys))
+	for k := range keys {
+		list = append(list, []byte(k))
+	}
+
+	// values are always loaded, deleted keys are only told apart by them
+	loading := opts
+	loading.KeysOnly = false
+
+	load := func(k []byte) ([]byte, error) {
+		return ms.LoadContext(ctx, k)
+	}
+
+	return scanKeys(list, loading, load, func(k, v []byte) bool {
+		if opts.KeysOnly {
+			v = nil
+		}
+
+		return fn(k, v)
+	})
+}
@@ -0,0 +1,135 @@
+package persistent
+
+import (
+	"errors"
+	"testing"
+)
+
+type brokenStorager struct{}
+
+func (brokenStorager) Save(k, v []byte) error {
+	return errors.New("broken")
+}
+
+func (brokenStorager) Load(k []byte) ([]byte, error) {
+	return nil, errors.New("broken")
+}
+
+func TestMirroredStorager(t *testing.T) {
+	replicas := []*MemoryStorager{NewMemoryStorager(), NewMemoryStorager(), NewMemoryStorager()}
+	storager := NewMirroredStorager(3, 3, replicas[0], replicas[1], replicas[2])
+
+	if err := storager.Save([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	dt, err := storager.Load([]byte("a"))
+	if err != nil || string(dt) != "1" {
+		t.Fatalf("expected 1, got %q, %v", dt, err)
+	}
+
+	// replica 2 lags behind, and replica 0 never saw the key
+	old, _ := replicas[2].Load([]byte("a"))
+	storager.Save([]byte("a"), []byte("2"))
+	replicas[2].Save([]byte("a"), old)
+	replicas[0] = NewMemoryStorager()
+	storager.replicas[0] = replicas[0]
+
+	dt, err = storager.Load([]byte("a"))
+	if err != nil || string(dt) != "2" {
+		t.Fatalf("expected the most recent value, got %q, %v", dt, err)
+	}
+
+	for i, r := range replicas {
+		dt, err := r.Load([]byte("a"))
+		if err != nil || string(dt[8:]) != "2" {
+			t.Fatalf("replica %d was not repaired: %q, %v", i, dt, err)
+		}
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMirroredStoragerQuorum(t *testing.T) {
+	healthy := NewMemoryStorager()
+	storager := NewMirroredStorager(2, 2, healthy, brokenStorager{}, NewMemoryStorager())
+
+	if err := storager.Save([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "1" {
+		t.Fatalf("expected 1, got %q, %v", dt, err)
+	}
+
+	storager = NewMirroredStorager(2, 2, healthy, brokenStorager{}, brokenStorager{})
+
+	if err := storager.Save([]byte("a"), []byte("2")); !errors.Is(err, ErrQuorum) {
+		t.Fatalf("expected ErrQuorum, got %v", err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrQuorum) {
+		t.Fatalf("expected ErrQuorum, got %v", err)
+	}
+}
+
+func TestMirroredStoragerStruct(t *testing.T) {
+	storager := NewMirroredStorager(2, 2, NewMemoryStorager(), NewMemoryStorager(), NewMemoryStorager())
+
+	type pessoa struct {
+		Nome   *PersistentString
+		Filhos *PersistentSlice
+	}
+
+	PersistStruct("pcarlos", &pessoa{
+		Nome:   NewPersistentString("Carlos"),
+		Filhos: &PersistentSlice{NewPersistentInt8(1), NewPersistentInt8(2)},
+	}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	nCarlos := &pessoa{}
+	RestoreStruct("pcarlos", nCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *nCarlos.Nome != "Carlos" || *(*nCarlos.Filhos)[1].(*PersistentInt8) != 2 {
+		t.Fail()
+	}
+}
+
+func TestMirroredStoragerDelete(t *testing.T) {
+	replicas := []*MemoryStorager{NewMemoryStorager(), NewMemoryStorager(), NewMemoryStorager()}
+	// every replica acknowledges the writes before they return, so the
+	// snapshot below holds the value
+	storager := NewMirroredStorager(3, 3, replicas[0], replicas[1], replicas[2])
+
+	storager.Save([]byte("a"), []byte("1"))
+	stale, _ := replicas[2].Load([]byte("a"))
+
+	if err := storager.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// replica 2 missed the delete and still holds the value
+	replicas[2].Save([]byte("a"), stale)
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the tombstone to win over a stale replica, got %v", err)
+	}
+
+	if err := storager.Save([]byte("a"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	dt, err := storager.Load([]byte("a"))
+	if err != nil || string(dt) != "2" {
+		t.Fatalf("expected a save after the delete to win, got %q, %v", dt, err)
+	}
+}
@@ -0,0 +1,120 @@
+package persistent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrInvalidNamespace = errors.New("namespace must not be empty")
+
+// NamespacedStorager isolates the keys of a namespace, such as a tenant,
+// inside a shared Storager. Every key is prefixed with the length of the
+// namespace followed by the namespace itself, so no key of one namespace can
+// be crafted to reach the keys of another, even when one namespace is a
+// prefix of the other. Namespaced storagers can be nested.
+type NamespacedStorager struct {
+	Storager Storager
+
+	namespace string
+	prefix    []byte
+}
+
+func NewNamespacedStorager(s Storager, namespace string) (*NamespacedStorager, error) {
+	if namespace == "" {
+		return nil, ErrInvalidNamespace
+	}
+
+	prefix := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(namespace))
+	prefix = append(prefix[:binary.PutUvarint(prefix, uint64(len(namespace)))], namespace...)
+
+	return &NamespacedStorager{
+		Storager:  s,
+		namespace: namespace,
+		prefix:    prefix,
+	}, nil
+}
+
+func (ns *NamespacedStorager) Namespace() string {
+	return ns.namespace
+}
+
+// Sub returns a namespace nested in this one.
+func (ns *NamespacedStorager) Sub(namespace string) (*NamespacedStorager, error) {
+	return NewNamespacedStorager(ns, namespace)
+}
+
+func (ns *NamespacedStorager) key(k []byte) []byte {
+	key := make([]byte, 0, len(ns.prefix)+len(k))
+	return append(append(key, ns.prefix...), k...)
+}
+
+func (ns *NamespacedStorager) Save(k, v []byte) error {
+	return ns.SaveContext(context.Background(), k, v)
+}
+
+func (ns *NamespacedStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	return saveContext(ctx, ns.Storager, ns.key(k), v)
+}
+
+func (ns *NamespacedStorager) Load(k []byte) ([]byte, error) {
+	return ns.LoadContext(context.Background(), k)
+}
+
+func (ns *NamespacedStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	return loadContext(ctx, ns.Storager, ns.key(k))
+}
+
+func (ns *NamespacedStorager) wrapped() []Storager {
+	return []Storager{ns.Storager}
+}
+
+func (ns *NamespacedStorager) Delete(k []byte) error {
+	return ns.DeleteContext(context.Background(), k)
+}
+
+func (ns *NamespacedStorager) DeleteContext(ctx context.Context, k []byte) error {
+	return DeleteContext(ctx, ns.Storager, ns.key(k))
+}
+
+func (ns *NamespacedStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return ns.ScanContext(context.Background(), opts, fn)
+}
+
+func (ns *NamespacedStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	inner := opts
+	inner.Prefix = ns.key(opts.Prefix)
+
+	if opts.Start != nil {
+		inner.Start = ns.key(opts.Start)
+	}
+
+	if opts.End != nil {
+		inner.End = ns.key(opts.End)
+	}
+
+	return ScanContext(ctx, ns.Storager, inner, func(k, v []byte) bool {
+		return fn(k[len(ns.prefix):], v)
+	})
+}
+
+func (ns *NamespacedStorager) Batch(ops []BatchOp) error {
+	return ns.BatchContext(context.Background(), ops)
+}
+
+func (ns *NamespacedStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	namespaced, _ := mapBatch(ops, func(op BatchOp) (BatchOp, error) {
+		op.Key = ns.key(op.Key)
+		return op, nil
+	})
+
+	return BatchContext(ctx, ns.Storager, namespaced)
+}
+
+func (ns *NamespacedStorager) CompareAndSwap(k, old, new []byte) (bool, error) {
+	return ns.CompareAndSwapContext(context.Background(), k, old, new)
+}
+
+func (ns *NamespacedStorager) CompareAndSwapContext(ctx context.Context, k, old, new []byte) (bool, error) {
+	return CompareAndSwapContext(ctx, ns.Storager, ns.key(k), old, new)
+}
@@ -0,0 +1,82 @@
+package persistent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNamespacedStorager(t *testing.T) {
+	backend := NewMemoryStorager()
+
+	tenantA, err := NewNamespacedStorager(backend, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantAB, err := NewNamespacedStorager(backend, "ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type pessoa struct {
+		Nome *PersistentString
+	}
+
+	for ns, s := range map[string]Storager{"a": tenantA, "ab": tenantAB} {
+		PersistStruct("user1", &pessoa{Nome: NewPersistentString(ns)}, s, func(e error) {
+			if e != nil {
+				t.Fatal(e)
+			}
+		})
+	}
+
+	for ns, s := range map[string]Storager{"a": tenantA, "ab": tenantAB} {
+		p := &pessoa{}
+		RestoreStruct("user1", p, s, func(e error) {
+			if e != nil {
+				t.Fatal(e)
+			}
+		})
+
+		if string(*p.Nome) != ns {
+			t.Fatalf("tenant %s restored the user of tenant %s", ns, *p.Nome)
+		}
+	}
+
+	// a key of tenant a starting with b cannot reach tenant ab
+	tenantA.Save([]byte("bkey"), []byte("a"))
+	if _, err := tenantAB.Load([]byte("key")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := NewNamespacedStorager(backend, ""); !errors.Is(err, ErrInvalidNamespace) {
+		t.Fatalf("expected ErrInvalidNamespace, got %v", err)
+	}
+}
+
+func TestNamespacedStoragerNested(t *testing.T) {
+	backend := NewMemoryStorager()
+
+	tenant, _ := NewNamespacedStorager(backend, "tenant")
+	cache, err := tenant.Sub("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flat, _ := NewNamespacedStorager(backend, "tenantcache")
+
+	cache.Save([]byte("k"), []byte("nested"))
+	flat.Save([]byte("k"), []byte("flat"))
+
+	if dt, err := cache.Load([]byte("k")); err != nil || string(dt) != "nested" {
+		t.Fatalf("expected nested, got %q, %v", dt, err)
+	}
+
+	if _, err := tenant.Load([]byte("k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if cache.Namespace() != "cache" || backend.Len() != 2 {
+		t.Fail()
+	}
+}
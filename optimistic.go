@@ -0,0 +1,614 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTxUnsettled is returned by Commit when the transaction was committed but
+// some of its locks could not be replaced with the new values. The writes are
+// not lost: Loads see them, and replace the locks left as they read them.
+var ErrTxUnsettled = errors.New("transaction committed with locks left to settle")
+
+const (
+	optimisticValue = byte(iota)
+	optimisticLock
+	optimisticCommitted
+)
+
+// kind | tx id | expiry in unix nanoseconds, followed by the fields of the
+// lock
+const optimisticLockHeaderSize = 1 + 8 + 8
+
+// optimisticEntry is a decoded value of an OptimisticStorager.
+type optimisticEntry struct {
+	// raw is the stored value, nil when the key is missing
+	raw []byte
+
+	// value is the last committed value, nil when there is none
+	value []byte
+
+	// locked is set while a Commit holds the key. committed is only set on
+	// the primary key of a Commit past its commit point, whose value already
+	// is the new one.
+	locked    bool
+	committed bool
+	txid      []byte
+	expiry    time.Time
+
+	// new is the value the Commit writes, nil for a delete
+	new []byte
+
+	// primary is the key whose lock decides whether the Commit happened, nil
+	// on the primary key itself, which lists the secondaries instead
+	primary     []byte
+	secondaries [][]byte
+}
+
+func encodeOptimisticValue(v []byte) []byte {
+	if v == nil {
+		return nil
+	}
+
+	return append([]byte{optimisticValue}, v...)
+}
+
+// appendOptimisticField appends f prefixed by its length plus one, so nil
+// and empty fields are told apart.
+func appendOptimisticField(dt, f []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	if f == nil {
+		return append(dt, n[:binary.PutUvarint(n[:], 0)]...)
+	}
+
+	dt = append(dt, n[:binary.PutUvarint(n[:], uint64(len(f))+1)]...)
+	return append(dt, f...)
+}
+
+func readOptimisticField(dt []byte) (f, rest []byte, ok bool) {
+	n, size := binary.Uvarint(dt)
+	if size <= 0 || n > uint64(len(dt)-size)+1 {
+		return nil, nil, false
+	}
+
+	if n == 0 {
+		return nil, dt[size:], true
+	}
+
+	end := size + int(n) - 1
+	return dt[size:end:end], dt[end:], true
+}
+
+// encodeOptimisticLock encodes the lock of e, which readers see as e.value
+// until it is released.
+func encodeOptimisticLock(e optimisticEntry) []byte {
+	dt := make([]byte, optimisticLockHeaderSize)
+	dt[0] = optimisticLock
+	if e.committed {
+		dt[0] = optimisticCommitted
+	}
+	copy(dt[1:9], e.txid)
+	binary.BigEndian.PutUint64(dt[9:], uint64(e.expiry.UnixNano()))
+
+	dt = appendOptimisticField(dt, e.value)
+	dt = appendOptimisticField(dt, e.new)
+	dt = appendOptimisticField(dt, e.primary)
+	for _, k := range e.secondaries {
+		dt = appendOptimisticField(dt, k)
+	}
+
+	return dt
+}
+
+func decodeOptimistic(k, raw []byte) (optimisticEntry, error) {
+	e := optimisticEntry{raw: raw}
+	invalid := fmt.Errorf("%q is not an optimistic value", k)
+
+	switch {
+	case raw == nil:
+		return e, nil
+	case len(raw) > 0 && raw[0] == optimisticValue:
+		e.value = raw[1:]
+		return e, nil
+	case len(raw) < optimisticLockHeaderSize || (raw[0] != optimisticLock && raw[0] != optimisticCommitted):
+		return e, invalid
+	}
+
+	e.locked = true
+	e.committed = raw[0] == optimisticCommitted
+	e.txid = raw[1:9]
+	e.expiry = time.Unix(0, int64(binary.BigEndian.Uint64(raw[9:])))
+
+	rest := raw[optimisticLockHeaderSize:]
+	for _, f := range []*[]byte{&e.value, &e.new, &e.primary} {
+		var ok bool
+		if *f, rest, ok = readOptimisticField(rest); !ok {
+			return e, invalid
+		}
+	}
+
+	for len(rest) > 0 {
+		sk, r, ok := readOptimisticField(rest)
+		if !ok {
+			return e, invalid
+		}
+
+		e.secondaries = append(e.secondaries, sk)
+		rest = r
+	}
+
+	return e, nil
+}
+
+// OptimisticStorager runs optimistic transactions over a Storager supporting
+// CompareAndSwap. Transactions read without locking anything. Commit locks
+// the keys written, in order, checks that the keys read still hold what was
+// read and then writes, failing with ErrConflict when another writer got
+// there first.
+//
+// The lock of the first key written is the primary one, and the locks of the
+// other keys point to it. A Commit takes effect the moment it marks the
+// primary lock as committed: a process dying before that has its locks
+// rolled back, one dying after has them rolled forward, by whoever reads
+// them once LockTimeout passed.
+//
+// Values are stored with a header, so every access to the keys must go
+// through the OptimisticStorager. Loads outside of a transaction see the
+// last committed value of each key.
+type OptimisticStorager struct {
+	Storager Storager
+
+	// LockTimeout is how long the keys locked by a Commit stay locked. Once
+	// it passed, the Commit is taken as abandoned and other transactions
+	// settle its keys, so it must be well above the time a Commit takes.
+	LockTimeout time.Duration
+}
+
+func NewOptimisticStorager(s Storager) *OptimisticStorager {
+	return &OptimisticStorager{Storager: s, LockTimeout: 30 * time.Second}
+}
+
+// load returns the raw value of k, nil when it is missing.
+func (opt *OptimisticStorager) load(ctx context.Context, k []byte) ([]byte, error) {
+	raw, err := loadContext(ctx, opt.Storager, k)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+
+	return raw, err
+}
+
+// read loads the entry of k, settling the lock of a Commit that passed its
+// commit point or was abandoned.
+func (opt *OptimisticStorager) read(ctx context.Context, k []byte) (optimisticEntry, error) {
+	for {
+		raw, err := opt.load(ctx, k)
+		if err != nil {
+			return optimisticEntry{}, err
+		}
+
+		e, err := decodeOptimistic(k, raw)
+		if err != nil || !e.locked {
+			return e, err
+		}
+
+		settled, err := opt.settle(ctx, k, e)
+		if err != nil || !settled {
+			return e, err
+		}
+	}
+}
+
+// settle rolls the lock e of k forward or back as its primary lock says,
+// reporting whether k may have changed. Locks of a Commit still running are
+// left alone.
+func (opt *OptimisticStorager) settle(ctx context.Context, k []byte, e optimisticEntry) (bool, error) {
+	s := opt.Storager
+	expired := !time.Now().Before(e.expiry)
+
+	if e.primary == nil {
+		if !expired {
+			return false, nil
+		}
+
+		// the Commit died, after its commit point when committed
+		if e.committed {
+			for _, sk := range e.secondaries {
+				if err := opt.rollForward(ctx, sk, e.txid); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		_, err := CompareAndSwapContext(ctx, s, k, e.raw, encodeOptimisticValue(e.value))
+		return true, err
+	}
+
+	raw, err := opt.load(ctx, e.primary)
+	if err != nil {
+		return false, err
+	}
+
+	p, err := decodeOptimistic(e.primary, raw)
+	if err != nil {
+		return false, err
+	}
+
+	ours := p.locked && bytes.Equal(p.txid, e.txid)
+
+	switch {
+	case ours && p.committed:
+		_, err = CompareAndSwapContext(ctx, s, k, e.raw, encodeOptimisticValue(e.new))
+	case ours && !expired:
+		return false, nil
+	case ours:
+		// rolling the primary back first keeps the Commit from reaching
+		// its commit point
+		_, err = CompareAndSwapContext(ctx, s, e.primary, raw, encodeOptimisticValue(p.value))
+	default:
+		// the primary lock was rolled back
+		_, err = CompareAndSwapContext(ctx, s, k, e.raw, encodeOptimisticValue(e.value))
+	}
+
+	return true, err
+}
+
+// rollForward writes the new value of the lock of transaction txid on k, if
+// it is still there.
+func (opt *OptimisticStorager) rollForward(ctx context.Context, k, txid []byte) error {
+	for {
+		raw, err := opt.load(ctx, k)
+		if err != nil {
+			return err
+		}
+
+		e, err := decodeOptimistic(k, raw)
+		if err != nil || !e.locked || !bytes.Equal(e.txid, txid) {
+			return err
+		}
+
+		swapped, err := CompareAndSwapContext(ctx, opt.Storager, k, raw, encodeOptimisticValue(e.new))
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// Load returns the last committed value of k.
+func (opt *OptimisticStorager) Load(k []byte) ([]byte, error) {
+	return opt.LoadContext(context.Background(), k)
+}
+
+func (opt *OptimisticStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	e, err := opt.read(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.value == nil {
+		return nil, ErrNotFound
+	}
+
+	return e.value, nil
+}
+
+// Save writes v with a CompareAndSwap of its own, trying again when another
+// writer got there first. While a Commit holds k, it waits with backoff for
+// the Commit to finish or, once LockTimeout passed, to be settled, unless ctx
+// is done first.
+func (opt *OptimisticStorager) Save(k, v []byte) error {
+	return opt.SaveContext(context.Background(), k, v)
+}
+
+func (opt *OptimisticStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	return opt.write(ctx, k, encodeOptimisticValue(append([]byte{}, v...)))
+}
+
+// Delete removes k as Save writes it.
+func (opt *OptimisticStorager) Delete(k []byte) error {
+	return opt.DeleteContext(context.Background(), k)
+}
+
+func (opt *OptimisticStorager) DeleteContext(ctx context.Context, k []byte) error {
+	return opt.write(ctx, k, nil)
+}
+
+func (opt *OptimisticStorager) write(ctx context.Context, k, raw []byte) error {
+	backoff := time.Millisecond
+
+	for {
+		e, err := opt.read(ctx, k)
+		if err != nil {
+			return err
+		}
+
+		if e.locked {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+
+			if backoff < 100*time.Millisecond {
+				backoff *= 2
+			}
+
+			continue
+		}
+
+		swapped, err := CompareAndSwapContext(ctx, opt.Storager, k, e.raw, raw)
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// Begin starts a transaction, or returns ErrCompareAndSwapUnsupported when
+// the wrapped Storager cannot swap values.
+func (opt *OptimisticStorager) Begin() (Tx, error) {
+	if !isCompareAndSwapper(opt.Storager) {
+		return nil, ErrCompareAndSwapUnsupported
+	}
+
+	return &optimisticTx{
+		opt:    opt,
+		reads:  map[string][]byte{},
+		writes: map[string][]byte{},
+	}, nil
+}
+
+type optimisticTx struct {
+	opt *OptimisticStorager
+
+	lock sync.Mutex
+	done bool
+
+	// reads holds the raw values read, nil for missing keys
+	reads map[string][]byte
+
+	// writes holds the values to commit, nil for deleted keys
+	writes map[string][]byte
+}
+
+func (tx *optimisticTx) Load(k []byte) ([]byte, error) {
+	tx.lock.Lock()
+	if tx.done {
+		tx.lock.Unlock()
+		return nil, ErrTxDone
+	}
+
+	v, written := tx.writes[string(k)]
+	raw, read := tx.reads[string(k)]
+	tx.lock.Unlock()
+
+	if written {
+		if v == nil {
+			return nil, ErrNotFound
+		}
+
+		return append([]byte{}, v...), nil
+	}
+
+	if !read {
+		e, err := tx.opt.read(context.Background(), k)
+		if err != nil {
+			return nil, err
+		}
+
+		tx.lock.Lock()
+		// a concurrent Load may have read k first, its read is the one
+		// validated
+		if _, read := tx.reads[string(k)]; !read {
+			tx.reads[string(k)] = e.raw
+		}
+		raw = tx.reads[string(k)]
+		tx.lock.Unlock()
+	}
+
+	e, err := decodeOptimistic(k, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.value == nil {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte{}, e.value...), nil
+}
+
+func (tx *optimisticTx) write(k, v []byte) error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.writes[string(k)] = v
+	return nil
+}
+
+func (tx *optimisticTx) Save(k, v []byte) error {
+	return tx.write(k, append([]byte{}, v...))
+}
+
+func (tx *optimisticTx) Delete(k []byte) error {
+	return tx.write(k, nil)
+}
+
+func (tx *optimisticTx) Rollback() error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+	return nil
+}
+
+type optimisticLocked struct {
+	k, lock, seen []byte
+}
+
+// Commit locks the written keys in order, the first one with the primary
+// lock, validates the keys only read and then marks the primary lock as
+// committed, the commit point of the transaction. The other locks are then
+// replaced with their new values, and the primary lock last. When the process
+// dies halfway, the locks are settled from the primary one once LockTimeout
+// passed, so the transaction is applied in whole or not at all. A failure to
+// write past the commit point is returned wrapped in ErrTxUnsettled.
+func (tx *optimisticTx) Commit() error {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	s := tx.opt.Storager
+
+	txid := make([]byte, 8)
+	if _, err := rand.Read(txid); err != nil {
+		return err
+	}
+	expiry := time.Now().Add(tx.opt.LockTimeout)
+
+	keys := make([][]byte, 0, len(tx.writes))
+	for k := range tx.writes {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	locked := []optimisticLocked{}
+	release := func() {
+		for _, l := range locked {
+			CompareAndSwap(s, l.k, l.lock, l.seen)
+		}
+	}
+
+	var primary optimisticEntry
+	for i, k := range keys {
+		seen, read := tx.reads[string(k)]
+		if !read {
+			// blind writes lock whatever they find
+			e, err := tx.opt.read(context.Background(), k)
+			if err != nil {
+				release()
+				return err
+			}
+			seen = e.raw
+		}
+
+		e, err := decodeOptimistic(k, seen)
+		if err != nil {
+			release()
+			return err
+		}
+
+		if e.locked {
+			release()
+			return fmt.Errorf("%q is locked by another transaction: %w", k, ErrConflict)
+		}
+
+		l := optimisticEntry{
+			value:  e.value,
+			new:    tx.writes[string(k)],
+			locked: true,
+			txid:   txid,
+			expiry: expiry,
+		}
+
+		if i == 0 {
+			l.secondaries = keys[1:]
+		} else {
+			l.primary = keys[0]
+		}
+
+		lock := encodeOptimisticLock(l)
+		swapped, err := CompareAndSwap(s, k, seen, lock)
+		if err != nil {
+			release()
+			return err
+		}
+
+		if !swapped {
+			release()
+			return fmt.Errorf("%q changed: %w", k, ErrConflict)
+		}
+
+		if i == 0 {
+			l.raw = lock
+			primary = l
+		}
+		locked = append(locked, optimisticLocked{k, lock, seen})
+	}
+
+	for k, seen := range tx.reads {
+		if _, written := tx.writes[k]; written {
+			continue
+		}
+
+		raw, err := tx.opt.load(context.Background(), []byte(k))
+		if err != nil {
+			release()
+			return err
+		}
+
+		e, _ := decodeOptimistic([]byte(k), seen)
+		if e.locked || !casMatches(raw, seen) {
+			release()
+			return fmt.Errorf("%q changed: %w", k, ErrConflict)
+		}
+	}
+
+	if len(locked) == 0 {
+		return nil
+	}
+
+	committed := primary
+	committed.committed = true
+	committed.value = primary.new
+	commit := encodeOptimisticLock(committed)
+
+	swapped, err := CompareAndSwap(s, keys[0], primary.raw, commit)
+	if err != nil {
+		// whether the commit record was written is unknown, the locks are
+		// left for the settling
+		return err
+	}
+
+	if !swapped {
+		release()
+		return fmt.Errorf("the lock of %q expired: %w", keys[0], ErrConflict)
+	}
+
+	// the transaction is committed, a lock already gone was rolled forward
+	// by a reader
+	for _, l := range locked[1:] {
+		if _, err := CompareAndSwap(s, l.k, l.lock, encodeOptimisticValue(tx.writes[string(l.k)])); err != nil {
+			return fmt.Errorf("%w: writing %q: %v", ErrTxUnsettled, l.k, err)
+		}
+	}
+
+	// the primary lock goes last, the others are found through it
+	if _, err := CompareAndSwap(s, keys[0], commit, encodeOptimisticValue(primary.new)); err != nil {
+		return fmt.Errorf("%w: writing %q: %v", ErrTxUnsettled, keys[0], err)
+	}
+
+	return nil
+}
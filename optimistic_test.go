@@ -0,0 +1,357 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOptimisticReadYourWrites(t *testing.T) {
+	storager := NewOptimisticStorager(NewMemoryStorager())
+	storager.Save([]byte("a"), []byte("1"))
+
+	tx, err := storager.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Save([]byte("b"), []byte("2"))
+	tx.Delete([]byte("a"))
+
+	if _, err := tx.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the tx to see its delete, got %v", err)
+	}
+
+	if dt, err := tx.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected the tx to see its save, got %q, %v", dt, err)
+	}
+
+	if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "1" {
+		t.Fatalf("expected nothing written before Commit, got %q, %v", dt, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected b to be saved, got %q, %v", dt, err)
+	}
+
+	if err := tx.Save([]byte("c"), nil); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+
+	tx, _ = storager.Begin()
+	tx.Save([]byte("c"), []byte("3"))
+	tx.Rollback()
+
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Fatalf("expected ErrTxDone, got %v", err)
+	}
+
+	if _, err := storager.Load([]byte("c")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the rolled back save to be dropped, got %v", err)
+	}
+}
+
+func TestOptimisticConflict(t *testing.T) {
+	storager := NewOptimisticStorager(NewMemoryStorager())
+	storager.Save([]byte("a"), []byte("1"))
+	storager.Save([]byte("b"), []byte("1"))
+
+	first, _ := storager.Begin()
+	second, _ := storager.Begin()
+
+	first.Load([]byte("a"))
+	second.Load([]byte("a"))
+
+	first.Save([]byte("a"), []byte("2"))
+	second.Save([]byte("b"), []byte("2"))
+
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected the stale read to conflict, got %v", err)
+	}
+
+	if dt, _ := storager.Load([]byte("b")); string(dt) != "1" {
+		t.Fatalf("expected the conflicting write to be dropped, got %q", dt)
+	}
+
+	tx, _ := storager.Begin()
+	tx.Load([]byte("c"))
+	tx.Save([]byte("c"), []byte("1"))
+	storager.Save([]byte("c"), []byte("2"))
+
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a key created since read to conflict, got %v", err)
+	}
+}
+
+func TestOptimisticCounter(t *testing.T) {
+	storager := NewOptimisticStorager(NewMemoryStorager())
+
+	type counter struct {
+		Count *PersistentInt64
+	}
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 10; i++ {
+				err := RunTx(storager, 1000, func(tx Tx) error {
+					c := &counter{Count: NewPersistentInt64(0)}
+					RestoreStruct("c", c, tx, func(error) {})
+					*c.Count++
+
+					var err error
+					PersistStruct("c", c, tx, func(e error) {
+						if e != nil {
+							err = e
+						}
+					})
+					return err
+				})
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	c := &counter{}
+	RestoreStruct("c", c, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *c.Count != 80 {
+		t.Fatalf("expected 80 increments, got %d", *c.Count)
+	}
+}
+
+func TestOptimisticExpiredLock(t *testing.T) {
+	memory := NewMemoryStorager()
+	storager := NewOptimisticStorager(memory)
+	storager.Save([]byte("a"), []byte("1"))
+
+	// a Commit that died after locking a
+	lock := optimisticEntry{
+		value:  []byte("1"),
+		new:    []byte("3"),
+		txid:   make([]byte, 8),
+		expiry: time.Now().Add(time.Minute),
+	}
+	memory.Save([]byte("a"), encodeOptimisticLock(lock))
+
+	if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "1" {
+		t.Fatalf("expected the committed value behind the lock, got %q, %v", dt, err)
+	}
+
+	tx, _ := storager.Begin()
+	tx.Save([]byte("a"), []byte("2"))
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected the locked key to conflict, got %v", err)
+	}
+
+	lock.expiry = time.Now().Add(-time.Second)
+	memory.Save([]byte("a"), encodeOptimisticLock(lock))
+
+	if err := storager.Save([]byte("a"), []byte("2")); err != nil {
+		t.Fatalf("expected the expired lock to be rolled back, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "2" {
+		t.Fatalf("unexpected value %q, %v", dt, err)
+	}
+}
+
+func TestOptimisticSettle(t *testing.T) {
+	txid := []byte("12345678")
+	before := time.Now().Add(-time.Second)
+	after := time.Now().Add(time.Minute)
+
+	secondary := func(expiry time.Time) []byte {
+		return encodeOptimisticLock(optimisticEntry{
+			value:   []byte("1"),
+			new:     []byte("2"),
+			txid:    txid,
+			expiry:  expiry,
+			primary: []byte("a"),
+		})
+	}
+
+	primary := func(expiry time.Time, committed bool) []byte {
+		e := optimisticEntry{
+			value:       []byte("1"),
+			new:         []byte("2"),
+			txid:        txid,
+			expiry:      expiry,
+			secondaries: [][]byte{[]byte("b"), []byte("c")},
+		}
+
+		if committed {
+			e.committed = true
+			e.value = e.new
+		}
+
+		return encodeOptimisticLock(e)
+	}
+
+	steps := []struct {
+		name    string
+		a, b, c []byte
+		value   string
+	}{
+		// the Commit died before its commit point
+		{"abandoned", primary(before, false), secondary(before), secondary(before), "1"},
+		{"running", primary(after, false), secondary(after), secondary(after), "1"},
+		{"rolled back primary", encodeOptimisticValue([]byte("1")), secondary(after), secondary(after), "1"},
+
+		// the Commit died after its commit point
+		{"committed", primary(after, true), secondary(after), secondary(after), "2"},
+		{"partly written", primary(after, true), encodeOptimisticValue([]byte("2")), secondary(after), "2"},
+		{"committed and abandoned", primary(before, true), secondary(before), secondary(before), "2"},
+	}
+
+	for _, step := range steps {
+		memory := NewMemoryStorager()
+		storager := NewOptimisticStorager(memory)
+
+		memory.Save([]byte("a"), step.a)
+		memory.Save([]byte("b"), step.b)
+		memory.Save([]byte("c"), step.c)
+
+		for _, k := range []string{"c", "b", "a"} {
+			dt, err := storager.Load([]byte(k))
+			if err != nil || string(dt) != step.value {
+				t.Fatalf("%s: expected %s to be %s, got %q, %v", step.name, k, step.value, dt, err)
+			}
+		}
+	}
+
+	// once abandoned, the primary lock settles every key
+	memory := NewMemoryStorager()
+	storager := NewOptimisticStorager(memory)
+	memory.Save([]byte("a"), primary(before, true))
+	memory.Save([]byte("b"), secondary(before))
+	memory.Save([]byte("c"), secondary(before))
+
+	storager.Load([]byte("a"))
+	for _, k := range []string{"a", "b", "c"} {
+		if raw, _ := memory.Load([]byte(k)); string(raw) != string(encodeOptimisticValue([]byte("2"))) {
+			t.Fatalf("expected %s to be rolled forward, got %q", k, raw)
+		}
+	}
+}
+
+// unsettlingStorager fails to replace the locks of key with values.
+type unsettlingStorager struct {
+	*MemoryStorager
+	key string
+}
+
+func (us *unsettlingStorager) CompareAndSwap(k, old, new []byte) (bool, error) {
+	if string(k) == us.key && len(new) > 0 && new[0] == optimisticValue {
+		return false, errors.New("unavailable")
+	}
+
+	return us.MemoryStorager.CompareAndSwap(k, old, new)
+}
+
+func TestOptimisticUnsettled(t *testing.T) {
+	memory := NewMemoryStorager()
+	storager := NewOptimisticStorager(&unsettlingStorager{memory, "b"})
+
+	err := RunTx(storager, 1, func(tx Tx) error {
+		tx.Save([]byte("a"), []byte("1"))
+		return tx.Save([]byte("b"), []byte("1"))
+	})
+
+	if !errors.Is(err, ErrTxUnsettled) {
+		t.Fatalf("expected ErrTxUnsettled, got %v", err)
+	}
+
+	// the lock left on b is rolled forward from the committed primary
+	for _, k := range []string{"a", "b"} {
+		dt, err := NewOptimisticStorager(memory).Load([]byte(k))
+		if err != nil || string(dt) != "1" {
+			t.Fatalf("expected %s to be committed, got %q, %v", k, dt, err)
+		}
+	}
+}
+
+func TestOptimisticSaveContext(t *testing.T) {
+	memory := NewMemoryStorager()
+	storager := NewOptimisticStorager(memory)
+
+	// a Commit holding a
+	memory.Save([]byte("a"), encodeOptimisticLock(optimisticEntry{
+		txid:   make([]byte, 8),
+		expiry: time.Now().Add(time.Minute),
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := storager.SaveContext(ctx, []byte("a"), []byte("1")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait for the lock to end with ctx, got %v", err)
+	}
+
+	if err := storager.DeleteContext(ctx, []byte("a")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait for the lock to end with ctx, got %v", err)
+	}
+}
+
+func TestOptimisticConcurrentSave(t *testing.T) {
+	storager := NewOptimisticStorager(NewMemoryStorager())
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				slc := PersistentSlice{NewPersistentInt64(1), NewPersistentInt64(2)}
+				if err := slc.Persist(storager, []byte("s")); err != nil {
+					t.Error(err)
+					return
+				}
+
+				err := RunTx(storager, 1000, func(tx Tx) error {
+					return tx.Save([]byte("s"), []byte("tx"))
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOptimisticUnsupported(t *testing.T) {
+	storager := NewOptimisticStorager(&testStorager{map[string][]byte{}})
+
+	if _, err := storager.Begin(); !errors.Is(err, ErrCompareAndSwapUnsupported) {
+		t.Fatalf("expected ErrCompareAndSwapUnsupported, got %v", err)
+	}
+}
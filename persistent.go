@@ -2,7 +2,9 @@ package persistent
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
@@ -57,25 +59,49 @@ func NewPersistentBool(v bool) *PersistentBool {
 type PersistentBool bool
 
 func (p *PersistentBool) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentBool) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentBoolPrefix}, k...)
 
-	if *p {
-		return s.Save(key, []byte{1})
-	} else {
-		return s.Save(key, []byte{0})
-	}
+	return traced(ctx, "persist", PersistentBoolPrefix, k, func(ctx context.Context) (int, error) {
+		if *p {
+			return 1, saveContext(ctx, s, key, []byte{1})
+		} else {
+			return 1, saveContext(ctx, s, key, []byte{0})
+		}
+	})
 }
 
 func (p *PersistentBool) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentBool) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentBoolPrefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentBoolPrefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
 
-	*p = dt[0] == byte(1)
-	return nil
+		if len(dt) != 1 {
+			return len(dt), fmt.Errorf("%s is not a bool", key)
+		}
+
+		*p = dt[0] == byte(1)
+		return len(dt), nil
+	})
+}
+
+func (p *PersistentBool) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+func (p *PersistentBool) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentBoolPrefix, s, k)
 }
 
 func EmptyPersistentInt8() *PersistentInt8 {
@@ -91,26 +117,74 @@ func NewPersistentInt8(v int8) *PersistentInt8 {
 
 type PersistentInt8 int8
 
-func (p *PersistentInt8) Persist(s Storager, key []byte) error {
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, int8(*p))
-	if err != nil {
-		return err
-	}
+func (p *PersistentInt8) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
 
-	return s.Save(key, buff.Bytes())
+func (p *PersistentInt8) PersistContext(ctx context.Context, s Storager, k []byte) error {
+	key := append([]byte{PersistentInt8Prefix}, k...)
+
+	return traced(ctx, "persist", PersistentInt8Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, int8(*p))
+		if err != nil {
+			return 0, err
+		}
+
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentInt8) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt8) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentInt8Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
+	return traced(ctx, "restore", PersistentInt8Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if errors.Is(err, ErrNotFound) {
+			// values saved before Persist used the prefix are under k
+			legacy, lerr := loadContext(ctx, s, k)
+			if lerr == nil && len(legacy) == 1 {
+				dt, err = legacy, nil
+			}
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentInt8) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+// EraseContext also deletes a value saved under k without the prefix, which
+// Restore would fall back to, when it is a single byte as PersistentInt8
+// values are.
+func (p *PersistentInt8) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	if err := eraseKey(ctx, PersistentInt8Prefix, s, k); err != nil {
 		return err
 	}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+	return traced(ctx, "erase", PersistentInt8Prefix, k, func(ctx context.Context) (int, error) {
+		legacy, err := loadContext(ctx, s, k)
+		if errors.Is(err, ErrNotFound) || err == nil && len(legacy) != 1 {
+			return 0, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, DeleteContext(ctx, s, k)
+	})
 }
 
 func EmptyPersistentInt16() *PersistentInt16 {
@@ -127,27 +201,47 @@ func NewPersistentInt16(v int16) *PersistentInt16 {
 type PersistentInt16 int16
 
 func (p *PersistentInt16) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt16) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentInt16Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, int16(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentInt16Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, int16(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentInt16) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt16) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentInt16Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentInt16Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentInt16) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt16) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentInt16Prefix, s, k)
 }
 
 func EmptyPersistentInt32() *PersistentInt32 {
@@ -164,27 +258,47 @@ func NewPersistentInt32(v int32) *PersistentInt32 {
 type PersistentInt32 int32
 
 func (p *PersistentInt32) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt32) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentInt32Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, int32(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentInt32Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, int32(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentInt32) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt32) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentInt32Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentInt32Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentInt32) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt32) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentInt32Prefix, s, k)
 }
 
 func EmptyPersistentInt64() *PersistentInt64 {
@@ -201,27 +315,47 @@ func NewPersistentInt64(v int64) *PersistentInt64 {
 type PersistentInt64 int64
 
 func (p *PersistentInt64) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt64) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentInt64Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, int64(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentInt64Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, int64(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentInt64) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt64) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentInt64Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentInt64Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
+
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+func (p *PersistentInt64) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+func (p *PersistentInt64) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentInt64Prefix, s, k)
 }
 
 func EmptyPersistentUint8() *PersistentUint8 {
@@ -238,27 +372,47 @@ func NewPersistentUint8(v uint8) *PersistentUint8 {
 type PersistentUint8 uint8
 
 func (p *PersistentUint8) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint8) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint8Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, uint8(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentUint8Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, uint8(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentUint8) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint8) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint8Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentUint8Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
+
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentUint8) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+func (p *PersistentUint8) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentUint8Prefix, s, k)
 }
 
 func EmptyPersistentUint16() *PersistentUint16 {
@@ -275,27 +429,47 @@ func NewPersistentUint16(v uint16) *PersistentUint16 {
 type PersistentUint16 uint16
 
 func (p *PersistentUint16) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint16) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint16Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, uint16(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentUint16Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, uint16(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentUint16) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint16) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint16Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentUint16Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
+
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentUint16) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+func (p *PersistentUint16) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentUint16Prefix, s, k)
 }
 
 func EmptyPersistentUint32() *PersistentUint32 {
@@ -312,27 +486,47 @@ func NewPersistentUint32(v uint32) *PersistentUint32 {
 type PersistentUint32 uint32
 
 func (p *PersistentUint32) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint32) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint32Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, uint32(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentUint32Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, uint32(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentUint32) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint32) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint32Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentUint32Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
+
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentUint32) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+func (p *PersistentUint32) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentUint32Prefix, s, k)
 }
 
 func EmptyPersistentUint64() *PersistentUint64 {
@@ -349,27 +543,47 @@ func NewPersistentUint64(v uint64) *PersistentUint64 {
 type PersistentUint64 uint64
 
 func (p *PersistentUint64) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint64) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint64Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, uint64(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentUint64Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, uint64(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentUint64) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentUint64) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentUint64Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentUint64Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
+
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentUint64) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+func (p *PersistentUint64) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentUint64Prefix, s, k)
 }
 
 func EmptyPersistentFloat32() *PersistentFloat32 {
@@ -386,27 +600,47 @@ func NewPersistentFloat32(v float32) *PersistentFloat32 {
 type PersistentFloat32 float32
 
 func (p *PersistentFloat32) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentFloat32) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentFloat32Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, float32(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentFloat32Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, float32(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentFloat32) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentFloat32) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentFloat32Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentFloat32Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentFloat32) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+func (p *PersistentFloat32) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentFloat32Prefix, s, k)
 }
 
 func EmptyPersistentFloat64() *PersistentFloat64 {
@@ -423,27 +657,47 @@ func NewPersistentFloat64(v float64) *PersistentFloat64 {
 type PersistentFloat64 float64
 
 func (p *PersistentFloat64) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentFloat64) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentFloat64Prefix}, k...)
 
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.LittleEndian, float64(*p))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "persist", PersistentFloat64Prefix, k, func(ctx context.Context) (int, error) {
+		buff := new(bytes.Buffer)
+		err := binary.Write(buff, binary.LittleEndian, float64(*p))
+		if err != nil {
+			return 0, err
+		}
 
-	return s.Save(key, buff.Bytes())
+		return buff.Len(), saveContext(ctx, s, key, buff.Bytes())
+	})
 }
 
 func (p *PersistentFloat64) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentFloat64) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentFloat64Prefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentFloat64Prefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
+
+		buff := bytes.NewBuffer(dt)
+		return len(dt), binary.Read(buff, binary.LittleEndian, p)
+	})
+}
+
+func (p *PersistentFloat64) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
 
-	buff := bytes.NewBuffer(dt)
-	return binary.Read(buff, binary.LittleEndian, p)
+func (p *PersistentFloat64) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentFloat64Prefix, s, k)
 }
 
 func EmptyPersistentByte() *PersistentByte {
@@ -460,25 +714,45 @@ func NewPersistentByte(v byte) *PersistentByte {
 type PersistentByte byte
 
 func (p *PersistentByte) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentByte) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentBytePrefix}, k...)
 
-	return s.Save(key, []byte{byte(*p)})
+	return traced(ctx, "persist", PersistentBytePrefix, k, func(ctx context.Context) (int, error) {
+		return 1, saveContext(ctx, s, key, []byte{byte(*p)})
+	})
 }
 
 func (p *PersistentByte) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentByte) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentBytePrefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentBytePrefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
 
-	if len(dt) != 1 {
-		return fmt.Errorf("%s is not a byte", key)
-	}
+		if len(dt) != 1 {
+			return len(dt), fmt.Errorf("%s is not a byte", key)
+		}
 
-	*p = PersistentByte(dt[0])
-	return nil
+		*p = PersistentByte(dt[0])
+		return len(dt), nil
+	})
+}
+
+func (p *PersistentByte) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+func (p *PersistentByte) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentBytePrefix, s, k)
 }
 
 func EmptyPersistentString() *PersistentString {
@@ -495,62 +769,115 @@ func NewPersistentString(v string) *PersistentString {
 type PersistentString string
 
 func (p *PersistentString) Persist(s Storager, k []byte) error {
+	return p.PersistContext(context.Background(), s, k)
+}
+
+func (p *PersistentString) PersistContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentStringPrefix}, k...)
-	return s.Save(key, []byte(*p))
+
+	return traced(ctx, "persist", PersistentStringPrefix, k, func(ctx context.Context) (int, error) {
+		return len(*p), saveContext(ctx, s, key, []byte(*p))
+	})
 }
 
 func (p *PersistentString) Restore(s Storager, k []byte) error {
+	return p.RestoreContext(context.Background(), s, k)
+}
+
+func (p *PersistentString) RestoreContext(ctx context.Context, s Storager, k []byte) error {
 	key := append([]byte{PersistentStringPrefix}, k...)
 
-	dt, err := s.Load([]byte(key))
-	if err != nil {
-		return err
-	}
+	return traced(ctx, "restore", PersistentStringPrefix, k, func(ctx context.Context) (int, error) {
+		dt, err := loadContext(ctx, s, []byte(key))
+		if err != nil {
+			return 0, err
+		}
 
-	*p = PersistentString(dt)
-	return nil
+		*p = PersistentString(dt)
+		return len(dt), nil
+	})
+}
+
+func (p *PersistentString) Erase(s Storager, k []byte) error {
+	return p.EraseContext(context.Background(), s, k)
+}
+
+func (p *PersistentString) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return eraseKey(ctx, PersistentStringPrefix, s, k)
 }
 
 type PersistentSlice []Persistent
 
+// persistentPrefix returns the key prefix of the type of p.
+func persistentPrefix(p Persistent) byte {
+	switch p.(type) {
+	case *PersistentBool:
+		return PersistentBoolPrefix
+	case *PersistentInt8:
+		return PersistentInt8Prefix
+	case *PersistentInt16:
+		return PersistentInt16Prefix
+	case *PersistentInt32:
+		return PersistentInt32Prefix
+	case *PersistentInt64:
+		return PersistentInt64Prefix
+	case *PersistentUint8:
+		return PersistentUint8Prefix
+	case *PersistentUint16:
+		return PersistentUint16Prefix
+	case *PersistentUint32:
+		return PersistentUint32Prefix
+	case *PersistentUint64:
+		return PersistentUint64Prefix
+	case *PersistentFloat32:
+		return PersistentFloat32Prefix
+	case *PersistentFloat64:
+		return PersistentFloat64Prefix
+	case *PersistentString:
+		return PersistentStringPrefix
+	case *PersistentSlice:
+		return PersistentSlicePrefix
+	case *PersistentByte:
+		return PersistentBytePrefix
+	}
+
+	return PersistentUndefinedPrefix
+}
+
 func (ps *PersistentSlice) Persist(s Storager, k []byte) error {
+	return ps.PersistContext(context.Background(), s, k)
+}
+
+// PersistContext persists the elements concurrently, cancelling the others
+// once one fails. When s deletes, the elements left over by a longer
+// slice or a slice of another type are erased. When s is a Batcher, the
+// elements and the length and type keys are written in a single batch.
+func (ps *PersistentSlice) PersistContext(ctx context.Context, s Storager, k []byte) error {
+	return traced(ctx, "persist", PersistentSlicePrefix, k, func(ctx context.Context) (int, error) {
+		return 0, batched(ctx, s, func(s Storager) error {
+			return ps.persist(ctx, s, k)
+		})
+	})
+}
+
+func (ps *PersistentSlice) persist(ctx context.Context, s Storager, k []byte) error {
 	var iPrefix byte = PersistentUndefinedPrefix
 
 	if len(*ps) > 0 {
-		switch []Persistent(*ps)[0].(type) {
-		default:
-			iPrefix = PersistentUndefinedPrefix
-		case *PersistentBool:
-			iPrefix = PersistentBoolPrefix
-		case *PersistentInt8:
-			iPrefix = PersistentInt8Prefix
-		case *PersistentInt16:
-			iPrefix = PersistentInt16Prefix
-		case *PersistentInt32:
-			iPrefix = PersistentInt32Prefix
-		case *PersistentInt64:
-			iPrefix = PersistentInt64Prefix
-		case *PersistentUint8:
-			iPrefix = PersistentUint8Prefix
-		case *PersistentUint16:
-			iPrefix = PersistentUint16Prefix
-		case *PersistentUint32:
-			iPrefix = PersistentUint32Prefix
-		case *PersistentUint64:
-			iPrefix = PersistentUint64Prefix
-		case *PersistentFloat32:
-			iPrefix = PersistentFloat32Prefix
-		case *PersistentFloat64:
-			iPrefix = PersistentFloat64Prefix
-		case *PersistentString:
-			iPrefix = PersistentStringPrefix
-		case *PersistentByte:
-			iPrefix = PersistentBytePrefix
-		}
+		iPrefix = persistentPrefix([]Persistent(*ps)[0])
 	}
 
 	key := append([]byte{PersistentSlicePrefix}, k...)
-	g := new(errgroup.Group)
+
+	// Storagers report missing keys with errors of their own, so a header
+	// that cannot be read leaves no elements to clean up
+	var oldLength uint64
+	var oldPrefix byte
+	if isDeleter(s) {
+		oldLength, oldPrefix, _ = restoreSliceHeader(ctx, s, key)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
 
 	lock := new(sync.Mutex)
 	i := 0
@@ -565,42 +892,137 @@ func (ps *PersistentSlice) Persist(s Storager, k []byte) error {
 			lock.Unlock()
 
 			k := []byte(fmt.Sprintf("%s:%c%d", key, iPrefix, index))
-			return p.Persist(s, k)
+			return PersistContext(gctx, p, s, k)
 		})
 	}
 
 	length := PersistentUint64(len(*ps))
-	err := length.Persist(s, append([]byte("l"), key...))
+	err := length.PersistContext(ctx, s, append([]byte("l"), key...))
 	if err != nil {
 		return err
 	}
 
 	prefix := PersistentByte(iPrefix)
-	err = prefix.Persist(s, append([]byte("t"), key...))
+	err = prefix.PersistContext(ctx, s, append([]byte("t"), key...))
 	if err != nil {
 		return err
 	}
 
-	return g.Wait()
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	from := uint64(len(*ps))
+	if oldPrefix != iPrefix {
+		from = 0
+	}
+
+	err = eraseSliceElements(ctx, s, key, oldPrefix, from, oldLength)
+	if errors.Is(err, ErrDeleteUnsupported) {
+		return nil
+	}
+
+	return err
 }
 
-func (ps *PersistentSlice) Restore(s Storager, k []byte) error {
-	key := append([]byte{PersistentSlicePrefix}, k...)
+// restoreSliceHeader loads the length and element type of a slice.
+func restoreSliceHeader(ctx context.Context, s Storager, key []byte) (uint64, byte, error) {
 	length := new(PersistentUint64)
-	err := length.Restore(s, append([]byte("l"), key...))
+	err := length.RestoreContext(ctx, s, append([]byte("l"), key...))
 	if err != nil {
-		return err
+		return 0, 0, err
 	}
 
 	prefix := new(PersistentByte)
-	err = prefix.Restore(s, append([]byte("t"), key...))
+	err = prefix.RestoreContext(ctx, s, append([]byte("t"), key...))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(*length), byte(*prefix), nil
+}
+
+// newPersistent returns an empty Persistent of the type with key prefix
+// prefix, or nil for unknown prefixes.
+func newPersistent(prefix byte) Persistent {
+	switch prefix {
+	case PersistentBoolPrefix:
+		return new(PersistentBool)
+	case PersistentInt8Prefix:
+		return new(PersistentInt8)
+	case PersistentInt16Prefix:
+		return new(PersistentInt16)
+	case PersistentInt32Prefix:
+		return new(PersistentInt32)
+	case PersistentInt64Prefix:
+		return new(PersistentInt64)
+	case PersistentUint8Prefix:
+		return new(PersistentUint8)
+	case PersistentUint16Prefix:
+		return new(PersistentUint16)
+	case PersistentUint32Prefix:
+		return new(PersistentUint32)
+	case PersistentUint64Prefix:
+		return new(PersistentUint64)
+	case PersistentFloat32Prefix:
+		return new(PersistentFloat32)
+	case PersistentFloat64Prefix:
+		return new(PersistentFloat64)
+	case PersistentStringPrefix:
+		return new(PersistentString)
+	case PersistentSlicePrefix:
+		return new(PersistentSlice)
+	case PersistentBytePrefix:
+		return new(PersistentByte)
+	}
+
+	return nil
+}
+
+// eraseSliceElements erases the elements of indexes [from, to) concurrently.
+func eraseSliceElements(ctx context.Context, s Storager, key []byte, prefix byte, from, to uint64) error {
+	if from >= to {
+		return nil
+	}
+
+	p := newPersistent(prefix)
+	if p == nil {
+		return fmt.Errorf("%s has elements of unknown type %d", key, prefix)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	for index := from; index < to; index++ {
+		iK := []byte(fmt.Sprintf("%s:%c%d", key, prefix, index))
+		g.Go(func() error {
+			return EraseContext(gctx, p, s, iK)
+		})
+	}
+
+	return g.Wait()
+}
+
+func (ps *PersistentSlice) Restore(s Storager, k []byte) error {
+	return ps.RestoreContext(context.Background(), s, k)
+}
+
+// RestoreContext restores the elements concurrently, cancelling the others
+// once one fails.
+func (ps *PersistentSlice) RestoreContext(ctx context.Context, s Storager, k []byte) error {
+	return traced(ctx, "restore", PersistentSlicePrefix, k, func(ctx context.Context) (int, error) {
+		return 0, ps.restore(ctx, s, k)
+	})
+}
+
+func (ps *PersistentSlice) restore(ctx context.Context, s Storager, k []byte) error {
+	key := append([]byte{PersistentSlicePrefix}, k...)
+	length, prefix, err := restoreSliceHeader(ctx, s, key)
 	if err != nil {
 		return err
 	}
 
-	slc := make([]Persistent, *length)
+	slc := make([]Persistent, length)
 
-	g := new(errgroup.Group)
+	g, gctx := errgroup.WithContext(ctx)
 
 	lock := new(sync.Mutex)
 	i := 0
@@ -613,40 +1035,14 @@ func (ps *PersistentSlice) Restore(s Storager, k []byte) error {
 			i++
 			lock.Unlock()
 
-			iK := []byte(fmt.Sprintf("%s:%c%d", key, *prefix, index))
-
-			switch byte(*prefix) {
-			case PersistentBoolPrefix:
-				slc[index] = new(PersistentBool)
-			case PersistentInt8Prefix:
-				slc[index] = new(PersistentInt8)
-			case PersistentInt16Prefix:
-				slc[index] = new(PersistentInt16)
-			case PersistentInt32Prefix:
-				slc[index] = new(PersistentInt32)
-			case PersistentInt64Prefix:
-				slc[index] = new(PersistentInt64)
-			case PersistentUint8Prefix:
-				slc[index] = new(PersistentUint8)
-			case PersistentUint16Prefix:
-				slc[index] = new(PersistentUint16)
-			case PersistentUint32Prefix:
-				slc[index] = new(PersistentUint32)
-			case PersistentUint64Prefix:
-				slc[index] = new(PersistentUint64)
-			case PersistentFloat32Prefix:
-				slc[index] = new(PersistentFloat32)
-			case PersistentFloat64Prefix:
-				slc[index] = new(PersistentFloat64)
-			case PersistentStringPrefix:
-				slc[index] = new(PersistentString)
-			case PersistentSlicePrefix:
-				slc[index] = new(PersistentSlice)
-			case PersistentBytePrefix:
-				slc[index] = new(PersistentByte)
+			iK := []byte(fmt.Sprintf("%s:%c%d", key, prefix, index))
+
+			slc[index] = newPersistent(prefix)
+			if slc[index] == nil {
+				return fmt.Errorf("%s has elements of unknown type %d", key, prefix)
 			}
 
-			return slc[index].Restore(s, iK)
+			return RestoreContext(gctx, slc[index], s, iK)
 		})
 	}
 
@@ -659,7 +1055,133 @@ func (ps *PersistentSlice) Restore(s Storager, k []byte) error {
 	return nil
 }
 
+func (ps *PersistentSlice) Erase(s Storager, k []byte) error {
+	return ps.EraseContext(context.Background(), s, k)
+}
+
+// EraseContext erases the elements and then the length and type keys, so an
+// interrupted erase can be run again. When s is a Batcher, they are all
+// deleted in a single batch.
+func (ps *PersistentSlice) EraseContext(ctx context.Context, s Storager, k []byte) error {
+	return traced(ctx, "erase", PersistentSlicePrefix, k, func(ctx context.Context) (int, error) {
+		return 0, batched(ctx, s, func(s Storager) error {
+			return ps.erase(ctx, s, k)
+		})
+	})
+}
+
+func (ps *PersistentSlice) erase(ctx context.Context, s Storager, k []byte) error {
+	key := append([]byte{PersistentSlicePrefix}, k...)
+	length, prefix, err := restoreSliceHeader(ctx, s, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := eraseSliceElements(ctx, s, key, prefix, 0, length); err != nil {
+		return err
+	}
+
+	if err := EmptyPersistentByte().EraseContext(ctx, s, append([]byte("t"), key...)); err != nil {
+		return err
+	}
+
+	return EmptyPersistentUint64().EraseContext(ctx, s, append([]byte("l"), key...))
+}
+
+// traceStruct wraps errHandler so the struct span ends with the first error
+// reported by a field.
+func traceStruct(ctx context.Context, op, id string, errHandler func(error)) (context.Context, func(error), func()) {
+	ctx, span := startSpan(ctx, TraceInfo{
+		Op:   op,
+		Key:  []byte(id),
+		Type: "struct",
+	})
+
+	var first error
+	handler := func(err error) {
+		if first == nil {
+			first = err
+		}
+		errHandler(err)
+	}
+
+	return ctx, handler, func() { span.End(0, first) }
+}
+
+// structBatch collects the writes of the struct helpers in a WriteBatch when
+// s is a Batcher. commit writes them unless a field failed, and reports the
+// error of the batch.
+func structBatch(s Storager, errHandler func(error)) (Storager, func(error), func(context.Context)) {
+	if !isBatcher(s) {
+		return s, errHandler, func(context.Context) {}
+	}
+
+	wb := NewWriteBatch(s)
+	failed := false
+
+	handler := func(err error) {
+		if err != nil {
+			failed = true
+		}
+		errHandler(err)
+	}
+
+	commit := func(ctx context.Context) {
+		if failed {
+			return
+		}
+
+		if err := wb.CommitContext(ctx); err != nil {
+			errHandler(err)
+		}
+	}
+
+	return wb, handler, commit
+}
+
+// traceField persists, restores or erases a struct field inside a span named
+// after the field.
+func traceField(ctx context.Context, op, field string, p Persistent, s Storager, k []byte) error {
+	ctx, span := startSpan(ctx, TraceInfo{
+		Op:    op,
+		Key:   k,
+		Type:  PrefixName([]byte{persistentPrefix(p)}),
+		Field: field,
+	})
+
+	var err error
+	switch op {
+	case "persist":
+		err = PersistContext(ctx, p, s, k)
+	case "restore":
+		err = RestoreContext(ctx, p, s, k)
+	case "erase":
+		err = EraseContext(ctx, p, s, k)
+	}
+
+	span.End(0, err)
+	return err
+}
+
+// PersistStruct persists the persistent fields of the struct pointed by dt.
+// When s is a Batcher, the fields are written in a single batch, which is
+// dropped when a field fails.
 func PersistStruct(id string, dt interface{}, s Storager, errHandler func(error)) {
+	PersistStructContext(context.Background(), id, dt, s, errHandler)
+}
+
+// PersistStructContext is PersistStruct stopping at the first field once ctx
+// is done, reporting the context error.
+func PersistStructContext(ctx context.Context, id string, dt interface{}, s Storager, errHandler func(error)) {
+	ctx, errHandler, end := traceStruct(ctx, "persist", id, errHandler)
+	defer end()
+
+	s, errHandler, commit := structBatch(s, errHandler)
+
 	dtType := reflect.TypeOf(dt).Elem()
 	dtValue := reflect.ValueOf(dt).Elem()
 
@@ -671,17 +1193,30 @@ func PersistStruct(id string, dt interface{}, s Storager, errHandler func(error)
 				continue
 			}
 
-			cRet := dtValue.Field(i).MethodByName("Persist").Call([]reflect.Value{
-				reflect.ValueOf(s),
-				reflect.ValueOf([]byte(fmt.Sprintf("%s/%s", id, dtType.Field(i).Name))),
-			})
+			if err := ctx.Err(); err != nil {
+				errHandler(err)
+				return
+			}
 
-			reflect.ValueOf(errHandler).Call(cRet)
+			name := dtType.Field(i).Name
+			errHandler(traceField(ctx, "persist", name, dtValue.Field(i).Interface().(Persistent), s,
+				[]byte(fmt.Sprintf("%s/%s", id, name))))
 		}
 	}
+
+	commit(ctx)
 }
 
 func RestoreStruct(id string, dt interface{}, s Storager, errHandler func(error)) {
+	RestoreStructContext(context.Background(), id, dt, s, errHandler)
+}
+
+// RestoreStructContext is RestoreStruct stopping at the first field once ctx
+// is done, reporting the context error.
+func RestoreStructContext(ctx context.Context, id string, dt interface{}, s Storager, errHandler func(error)) {
+	ctx, errHandler, end := traceStruct(ctx, "restore", id, errHandler)
+	defer end()
+
 	dtType := reflect.TypeOf(dt).Elem()
 	dtValue := reflect.ValueOf(dt).Elem()
 
@@ -693,12 +1228,49 @@ func RestoreStruct(id string, dt interface{}, s Storager, errHandler func(error)
 				dtValue.Field(i).Set(reflect.New(dtType.Field(i).Type.Elem()))
 			}
 
-			cRet := dtValue.Field(i).MethodByName("Restore").Call([]reflect.Value{
-				reflect.ValueOf(s),
-				reflect.ValueOf([]byte(fmt.Sprintf("%s/%s", id, dtType.Field(i).Name))),
-			})
+			if err := ctx.Err(); err != nil {
+				errHandler(err)
+				return
+			}
+
+			name := dtType.Field(i).Name
+			errHandler(traceField(ctx, "restore", name, dtValue.Field(i).Interface().(Persistent), s,
+				[]byte(fmt.Sprintf("%s/%s", id, name))))
+		}
+	}
+}
+
+// DeleteStruct erases the persistent fields of the struct pointed by dt from
+// s. Only the type of dt matters, its values are left untouched. Like
+// PersistStruct, it deletes in a single batch when s is a Batcher.
+func DeleteStruct(id string, dt interface{}, s Storager, errHandler func(error)) {
+	DeleteStructContext(context.Background(), id, dt, s, errHandler)
+}
+
+// DeleteStructContext is DeleteStruct stopping at the first field once ctx
+// is done, reporting the context error.
+func DeleteStructContext(ctx context.Context, id string, dt interface{}, s Storager, errHandler func(error)) {
+	ctx, errHandler, end := traceStruct(ctx, "erase", id, errHandler)
+	defer end()
 
-			reflect.ValueOf(errHandler).Call(cRet)
+	s, errHandler, commit := structBatch(s, errHandler)
+
+	dtType := reflect.TypeOf(dt).Elem()
+
+	pType := reflect.TypeOf((*Persistent)(nil)).Elem()
+
+	for i := 0; i < dtType.NumField(); i++ {
+		if dtType.Field(i).Type.Implements(pType) {
+			if err := ctx.Err(); err != nil {
+				errHandler(err)
+				return
+			}
+
+			name := dtType.Field(i).Name
+			p := reflect.New(dtType.Field(i).Type.Elem()).Interface().(Persistent)
+			errHandler(traceField(ctx, "erase", name, p, s, []byte(fmt.Sprintf("%s/%s", id, name))))
 		}
 	}
+
+	commit(ctx)
 }
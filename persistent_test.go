@@ -1,7 +1,9 @@
 package persistent
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"testing"
 )
 
@@ -23,6 +25,31 @@ func (ts *testStorager) Load(k []byte) ([]byte, error) {
 	return val, nil
 }
 
+// lockedStorager is a testStorager safe for the concurrent calls of a
+// PersistentSlice.
+type lockedStorager struct {
+	testStorager
+	lock sync.Mutex
+}
+
+func newLockedStorager() *lockedStorager {
+	return &lockedStorager{testStorager: testStorager{map[string][]byte{}}}
+}
+
+func (ls *lockedStorager) Save(k, v []byte) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	return ls.testStorager.Save(k, v)
+}
+
+func (ls *lockedStorager) Load(k []byte) ([]byte, error) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	return ls.testStorager.Load(k)
+}
+
 func TestBool(t *testing.T) {
 	storager := &testStorager{map[string][]byte{}}
 
@@ -43,6 +70,15 @@ func TestBool(t *testing.T) {
 	}
 }
 
+func TestBoolEmpty(t *testing.T) {
+	storager := &testStorager{map[string][]byte{}}
+	storager.Save([]byte{PersistentBoolPrefix, 't'}, []byte{})
+
+	if err := EmptyPersistentBool().Restore(storager, []byte("t")); err == nil {
+		t.Fatal("expected an error restoring an empty bool")
+	}
+}
+
 func TestStr(t *testing.T) {
 	storager := &testStorager{map[string][]byte{}}
 
@@ -83,6 +119,47 @@ func TestInt8(t *testing.T) {
 	}
 }
 
+func TestInt8Legacy(t *testing.T) {
+	storager := NewMemoryStorager()
+	storager.Save([]byte("test"), []byte{0xda})
+
+	nTest := EmptyPersistentInt8()
+	if err := nTest.Restore(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if *nTest != -38 {
+		t.Fatalf("expected the unprefixed value to be restored, got %d", *nTest)
+	}
+
+	if err := nTest.Erase(storager, []byte("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nTest.Restore(storager, []byte("test")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the unprefixed value to be erased, got %v", err)
+	}
+
+	// only a missing key falls back to the unprefixed one
+	storager.Save([]byte("test"), []byte{0xda})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := nTest.RestoreContext(ctx, storager, []byte("test")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the failed load to be returned, got %v", err)
+	}
+
+	// values of other types under the unprefixed key are left alone
+	storager.Save([]byte("other"), []byte("not an int8"))
+	if err := nTest.Erase(storager, []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if dt, err := storager.Load([]byte("other")); err != nil || string(dt) != "not an int8" {
+		t.Fatalf("expected the unrelated value to stay, got %q, %v", dt, err)
+	}
+}
+
 func TestInt16(t *testing.T) {
 	storager := &testStorager{map[string][]byte{}}
 
@@ -285,7 +362,7 @@ func TestByte(t *testing.T) {
 }
 
 func TestSlice(t *testing.T) {
-	storager := &testStorager{map[string][]byte{}}
+	storager := newLockedStorager()
 
 	testSlice := PersistentSlice([]Persistent{
 		NewPersistentInt64(-44),
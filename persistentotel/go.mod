@@ -0,0 +1,22 @@
+module github.com/carlosmpv/persistent/persistentotel
+
+go 1.17
+
+require (
+	github.com/carlosmpv/persistent v0.1.0
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+)
+
+require (
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab // indirect
+)
+
+// v0.1.0 is the first release with SetTracer, and is tagged before
+// persistentotel/v0.1.0. The replace only applies when building in this
+// repository, and is ignored by the modules requiring persistentotel.
+replace github.com/carlosmpv/persistent => ../
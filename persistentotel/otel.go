@@ -0,0 +1,77 @@
+// Package persistentotel reports the spans of the persistent package to
+// OpenTelemetry.
+package persistentotel
+
+import (
+	"context"
+	"encoding/hex"
+	"unicode/utf8"
+
+	"github.com/carlosmpv/persistent"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the OpenTelemetry tracer.
+const InstrumentationName = "github.com/carlosmpv/persistent"
+
+// Tracer is a persistent.Tracer starting OpenTelemetry spans named after the
+// operation and value type, such as "persist string", with the key, type,
+// field and size as attributes.
+type Tracer struct {
+	Tracer trace.Tracer
+}
+
+// NewTracer returns a Tracer using the tracer provider tp, or the global one
+// when tp is nil.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return &Tracer{Tracer: tp.Tracer(InstrumentationName)}
+}
+
+func (t *Tracer) Start(ctx context.Context, info persistent.TraceInfo) (context.Context, persistent.TraceSpan) {
+	attrs := []attribute.KeyValue{
+		attribute.String("persistent.key", keyString(info.Key)),
+		attribute.String("persistent.type", info.Type),
+	}
+
+	if info.Field != "" {
+		attrs = append(attrs, attribute.String("persistent.field", info.Field))
+	}
+
+	ctx, span := t.Tracer.Start(ctx, info.Op+" "+info.Type,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...),
+	)
+
+	return ctx, otelSpan{span}
+}
+
+// keyString keeps printable keys readable and hex encodes binary ones.
+func keyString(k []byte) string {
+	if utf8.Valid(k) {
+		return string(k)
+	}
+
+	return hex.EncodeToString(k)
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) End(size int, err error) {
+	s.span.SetAttributes(attribute.Int("persistent.size", size))
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+
+	s.span.End()
+}
@@ -0,0 +1,73 @@
+package persistentotel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/carlosmpv/persistent"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	persistent.SetTracer(NewTracer(tp))
+	defer persistent.SetTracer(nil)
+
+	s := persistent.NewMemoryStorager()
+	persistent.NewPersistentInt64(64).Persist(s, []byte("a"))
+	persistent.EmptyPersistentInt64().Restore(s, []byte("\xff"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	if spans[0].Name() != "persist int64" {
+		t.Fatalf("unexpected span name %q", spans[0].Name())
+	}
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+
+	if attrs["persistent.key"].AsString() != "a" || attrs["persistent.size"].AsInt64() != 8 {
+		t.Fatalf("unexpected attributes %v", spans[0].Attributes())
+	}
+
+	if spans[1].Status().Code != codes.Error || len(spans[1].Events()) != 1 {
+		t.Fatalf("expected the failed restore to be recorded, got %+v", spans[1].Status())
+	}
+
+	for _, kv := range spans[1].Attributes() {
+		if kv.Key == "persistent.key" && kv.Value.AsString() != "ff" {
+			t.Fatalf("expected a hex encoded key, got %q", kv.Value.AsString())
+		}
+	}
+}
+
+func TestTracerParent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	persistent.SetTracer(NewTracer(tp))
+	defer persistent.SetTracer(nil)
+
+	ctx, caller := tp.Tracer("test").Start(context.Background(), "caller")
+	persistent.PersistContext(ctx, persistent.NewPersistentInt64(64), persistent.NewMemoryStorager(), []byte("a"))
+	caller.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "persist int64" {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+
+	if spans[0].Parent().SpanID() != caller.SpanContext().SpanID() {
+		t.Fatal("expected the span to be a child of the caller's span")
+	}
+}
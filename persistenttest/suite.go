@@ -0,0 +1,552 @@
+// Package persistenttest checks that Storager implementations honor the
+// contract the persistent types rely on.
+package persistenttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/carlosmpv/persistent"
+)
+
+// RunStoragerSuite runs the conformance tests against the storagers built by
+// factory, which is called once per test and may use t.Cleanup to release
+// them. The Delete, Scan, Batch and CompareAndSwap tests are skipped for
+// storagers that do not support them.
+func RunStoragerSuite(t *testing.T, factory func(t *testing.T) persistent.Storager) {
+	tests := []struct {
+		name string
+		test func(*testing.T, persistent.Storager)
+	}{
+		{"BinaryKeys", testBinaryKeys},
+		{"EmptyValue", testEmptyValue},
+		{"LargeValue", testLargeValue},
+		{"Overwrite", testOverwrite},
+		{"NotFound", testNotFound},
+		{"Concurrent", testConcurrent},
+		{"Types", testTypes},
+		{"Slice", testSlice},
+		{"Struct", testStruct},
+		{"Delete", testDelete},
+		{"Scan", testScan},
+		{"Batch", testBatch},
+		{"CompareAndSwap", testCompareAndSwap},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+func mustSave(t *testing.T, s persistent.Storager, k, v []byte) {
+	t.Helper()
+
+	if err := s.Save(k, v); err != nil {
+		t.Fatalf("Save(%q): %v", k, err)
+	}
+}
+
+func mustLoad(t *testing.T, s persistent.Storager, k, expected []byte) {
+	t.Helper()
+
+	dt, err := s.Load(k)
+	if err != nil {
+		t.Fatalf("Load(%q): %v", k, err)
+	}
+
+	if !bytes.Equal(dt, expected) {
+		t.Fatalf("Load(%q): expected %d bytes, got %d", k, len(expected), len(dt))
+	}
+}
+
+func testBinaryKeys(t *testing.T, s persistent.Storager) {
+	keys := [][]byte{
+		{},
+		{persistent.PersistentSlicePrefix},
+		{0},
+		{0xff, 0xfe},
+		[]byte("pcarlos/Nome"),
+		[]byte("../../etc/passwd"),
+		[]byte("a\r\nb c%2F"),
+	}
+
+	for c := 0; c < 256; c++ {
+		keys = append(keys, []byte{persistent.PersistentStringPrefix, byte(c), 'k'})
+	}
+
+	for i, k := range keys {
+		mustSave(t, s, k, []byte(fmt.Sprint(i)))
+	}
+
+	for i, k := range keys {
+		mustLoad(t, s, k, []byte(fmt.Sprint(i)))
+	}
+}
+
+func testEmptyValue(t *testing.T, s persistent.Storager) {
+	mustSave(t, s, []byte("empty"), []byte{})
+	mustLoad(t, s, []byte("empty"), []byte{})
+
+	mustSave(t, s, []byte("nil"), nil)
+	mustLoad(t, s, []byte("nil"), []byte{})
+}
+
+func testLargeValue(t *testing.T, s persistent.Storager) {
+	v := make([]byte, 1<<20)
+	for i := range v {
+		v[i] = byte(i * 7)
+	}
+
+	mustSave(t, s, []byte("large"), v)
+	mustLoad(t, s, []byte("large"), v)
+}
+
+func testOverwrite(t *testing.T, s persistent.Storager) {
+	mustSave(t, s, []byte("k"), []byte("a long first value"))
+	mustSave(t, s, []byte("k"), []byte("short"))
+	mustLoad(t, s, []byte("k"), []byte("short"))
+
+	v := []byte("caller's buffer")
+	mustSave(t, s, []byte("k"), v)
+	v[0] = 'X'
+	mustLoad(t, s, []byte("k"), []byte("caller's buffer"))
+}
+
+func testNotFound(t *testing.T, s persistent.Storager) {
+	if _, err := s.Load([]byte("missing")); !errors.Is(err, persistent.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	mustSave(t, s, []byte("missing/child"), []byte("1"))
+	if _, err := s.Load([]byte("missing")); !errors.Is(err, persistent.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func testConcurrent(t *testing.T, s persistent.Storager) {
+	wg := new(sync.WaitGroup)
+
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				k := []byte(fmt.Sprintf("g%d/k%d", g, i%5))
+				v := []byte(fmt.Sprintf("%d-%d", g, i))
+
+				if err := s.Save(k, v); err != nil {
+					t.Errorf("Save(%q): %v", k, err)
+					return
+				}
+
+				dt, err := s.Load(k)
+				if err != nil {
+					t.Errorf("Load(%q): %v", k, err)
+					return
+				}
+
+				if !bytes.Equal(dt, v) {
+					t.Errorf("Load(%q): expected %s, got %s", k, v, dt)
+					return
+				}
+
+				if err := s.Save([]byte("shared"), v); err != nil {
+					t.Errorf("Save(shared): %v", err)
+					return
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	if _, err := s.Load([]byte("shared")); err != nil {
+		t.Fatalf("Load(shared): %v", err)
+	}
+}
+
+func testTypes(t *testing.T, s persistent.Storager) {
+	values := []struct {
+		in, out persistent.Persistent
+	}{
+		{persistent.NewPersistentBool(true), persistent.EmptyPersistentBool()},
+		{persistent.NewPersistentInt8(-8), persistent.EmptyPersistentInt8()},
+		{persistent.NewPersistentInt16(-16), persistent.EmptyPersistentInt16()},
+		{persistent.NewPersistentInt32(-32), persistent.EmptyPersistentInt32()},
+		{persistent.NewPersistentInt64(-64), persistent.EmptyPersistentInt64()},
+		{persistent.NewPersistentUint8(8), persistent.EmptyPersistentUint8()},
+		{persistent.NewPersistentUint16(16), persistent.EmptyPersistentUint16()},
+		{persistent.NewPersistentUint32(32), persistent.EmptyPersistentUint32()},
+		{persistent.NewPersistentUint64(64), persistent.EmptyPersistentUint64()},
+		{persistent.NewPersistentFloat32(-3.2), persistent.EmptyPersistentFloat32()},
+		{persistent.NewPersistentFloat64(6.4), persistent.EmptyPersistentFloat64()},
+		{persistent.NewPersistentByte('b'), persistent.EmptyPersistentByte()},
+		{persistent.NewPersistentString("Olá mundo!"), persistent.EmptyPersistentString()},
+		{persistent.NewPersistentString(""), persistent.NewPersistentString("not empty")},
+	}
+
+	for i, v := range values {
+		k := []byte(fmt.Sprintf("value%d", i))
+
+		if err := v.in.Persist(s, k); err != nil {
+			t.Fatalf("%T.Persist: %v", v.in, err)
+		}
+
+		if err := v.out.Restore(s, k); err != nil {
+			t.Fatalf("%T.Restore: %v", v.out, err)
+		}
+
+		if fmt.Sprint(deref(v.in)) != fmt.Sprint(deref(v.out)) {
+			t.Fatalf("%T: persisted %v, restored %v", v.in, deref(v.in), deref(v.out))
+		}
+	}
+}
+
+func deref(p persistent.Persistent) interface{} {
+	switch v := p.(type) {
+	case *persistent.PersistentBool:
+		return *v
+	case *persistent.PersistentInt8:
+		return *v
+	case *persistent.PersistentInt16:
+		return *v
+	case *persistent.PersistentInt32:
+		return *v
+	case *persistent.PersistentInt64:
+		return *v
+	case *persistent.PersistentUint8:
+		return *v
+	case *persistent.PersistentUint16:
+		return *v
+	case *persistent.PersistentUint32:
+		return *v
+	case *persistent.PersistentUint64:
+		return *v
+	case *persistent.PersistentFloat32:
+		return *v
+	case *persistent.PersistentFloat64:
+		return *v
+	case *persistent.PersistentByte:
+		return *v
+	case *persistent.PersistentString:
+		return *v
+	}
+
+	return p
+}
+
+func testSlice(t *testing.T, s persistent.Storager) {
+	slc := persistent.PersistentSlice{}
+	for i := 0; i < 50; i++ {
+		slc = append(slc, persistent.NewPersistentInt64(int64(i*i)))
+	}
+
+	if err := slc.Persist(s, []byte("slice")); err != nil {
+		t.Fatal(err)
+	}
+
+	nSlc := persistent.PersistentSlice{}
+	if err := nSlc.Restore(s, []byte("slice")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nSlc) != len(slc) {
+		t.Fatalf("expected %d elements, got %d", len(slc), len(nSlc))
+	}
+
+	for i := range slc {
+		if *nSlc[i].(*persistent.PersistentInt64) != *slc[i].(*persistent.PersistentInt64) {
+			t.Fatalf("element %d differs", i)
+		}
+	}
+}
+
+func testStruct(t *testing.T, s persistent.Storager) {
+	type pessoa struct {
+		Nome   *persistent.PersistentString
+		Idade  *persistent.PersistentUint32
+		Altura *persistent.PersistentFloat32
+		Filhos *persistent.PersistentSlice
+	}
+
+	pCarlos := &pessoa{
+		Nome:   persistent.NewPersistentString("Carlos"),
+		Idade:  persistent.NewPersistentUint32(21),
+		Altura: persistent.NewPersistentFloat32(1.79),
+		Filhos: &persistent.PersistentSlice{persistent.NewPersistentString("Ana")},
+	}
+
+	persistent.PersistStruct("pcarlos", pCarlos, s, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	nCarlos := &pessoa{}
+	persistent.RestoreStruct("pcarlos", nCarlos, s, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *pCarlos.Nome != *nCarlos.Nome ||
+		*pCarlos.Idade != *nCarlos.Idade ||
+		*pCarlos.Altura != *nCarlos.Altura ||
+		len(*nCarlos.Filhos) != 1 {
+
+		t.Fatalf("restored %+v", nCarlos)
+	}
+}
+
+func mustNotFind(t *testing.T, s persistent.Storager, k []byte) {
+	t.Helper()
+
+	if _, err := s.Load(k); !errors.Is(err, persistent.ErrNotFound) {
+		t.Fatalf("Load(%q): expected ErrNotFound, got %v", k, err)
+	}
+}
+
+func testDelete(t *testing.T, s persistent.Storager) {
+	err := persistent.Delete(s, []byte("missing"))
+	if errors.Is(err, persistent.ErrDeleteUnsupported) {
+		t.Skip(err)
+	}
+
+	if err != nil {
+		t.Fatalf("Delete(missing): %v", err)
+	}
+
+	mustSave(t, s, []byte("k"), []byte("v"))
+	mustSave(t, s, []byte("k/child"), []byte("v"))
+	if err := persistent.Delete(s, []byte("k")); err != nil {
+		t.Fatalf("Delete(k): %v", err)
+	}
+
+	mustNotFind(t, s, []byte("k"))
+	mustLoad(t, s, []byte("k/child"), []byte("v"))
+
+	mustSave(t, s, []byte("k"), []byte("again"))
+	mustLoad(t, s, []byte("k"), []byte("again"))
+
+	slc := persistent.PersistentSlice{}
+	for i := 0; i < 10; i++ {
+		slc = append(slc, persistent.NewPersistentString(fmt.Sprint(i)))
+	}
+
+	if err := slc.Persist(s, []byte("slice")); err != nil {
+		t.Fatal(err)
+	}
+
+	// shrinking the slice erases the elements past its new length
+	short := slc[:3]
+	if err := short.Persist(s, []byte("slice")); err != nil {
+		t.Fatal(err)
+	}
+
+	element := func(i int) []byte {
+		return []byte(fmt.Sprintf("%cslice:%c%d", persistent.PersistentSlicePrefix, persistent.PersistentStringPrefix, i))
+	}
+
+	mustNotFind(t, s, append([]byte{persistent.PersistentStringPrefix}, element(3)...))
+
+	if err := slc.Erase(s, []byte("slice")); err != nil {
+		t.Fatal(err)
+	}
+
+	mustNotFind(t, s, append([]byte{persistent.PersistentStringPrefix}, element(0)...))
+
+	nSlc := persistent.PersistentSlice{}
+	if err := nSlc.Restore(s, []byte("slice")); !errors.Is(err, persistent.ErrNotFound) {
+		t.Fatalf("expected the erased slice to be missing, got %v", err)
+	}
+}
+
+// scanKeys returns the keys visited by a Scan, checking their values against
+// the ones saved.
+func scanKeys(t *testing.T, s persistent.Storager, opts persistent.ScanOptions, saved map[string][]byte) []string {
+	t.Helper()
+
+	keys := []string{}
+	err := persistent.Scan(s, opts, func(k, v []byte) bool {
+		if opts.KeysOnly && v != nil {
+			t.Errorf("Scan(%q): expected no value with KeysOnly", k)
+		}
+
+		if !opts.KeysOnly && !bytes.Equal(v, saved[string(k)]) {
+			t.Errorf("Scan(%q): expected %q, got %q", k, saved[string(k)], v)
+		}
+
+		keys = append(keys, string(k))
+		return true
+	})
+
+	if err != nil {
+		t.Fatalf("Scan(%+v): %v", opts, err)
+	}
+
+	return keys
+}
+
+func testScan(t *testing.T, s persistent.Storager) {
+	err := persistent.Scan(s, persistent.ScanOptions{}, func(k, v []byte) bool { return true })
+	if errors.Is(err, persistent.ErrScanUnsupported) {
+		t.Skip(err)
+	}
+
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	saved := map[string][]byte{}
+	for _, k := range []string{"a", "a/1", "a/2", "a\xff", "ab", "b", "\x00", "\xff", "\xff\xff", "a*", "a["} {
+		saved[k] = []byte("value of " + k)
+		mustSave(t, s, []byte(k), saved[k])
+	}
+	saved[""] = []byte{}
+	mustSave(t, s, []byte{}, nil)
+
+	tests := []struct {
+		opts     persistent.ScanOptions
+		expected []string
+	}{
+		{persistent.ScanOptions{}, []string{"", "\x00", "a", "a*", "a/1", "a/2", "a[", "ab", "a\xff", "b", "\xff", "\xff\xff"}},
+		{persistent.ScanOptions{Prefix: []byte("a")}, []string{"a", "a*", "a/1", "a/2", "a[", "ab", "a\xff"}},
+		{persistent.ScanOptions{Prefix: []byte("a/")}, []string{"a/1", "a/2"}},
+		{persistent.ScanOptions{Prefix: []byte("a*")}, []string{"a*"}},
+		{persistent.ScanOptions{Prefix: []byte("\xff")}, []string{"\xff", "\xff\xff"}},
+		{persistent.ScanOptions{Prefix: []byte("c")}, []string{}},
+		{persistent.ScanOptions{Start: []byte("a/"), End: []byte("ab")}, []string{"a/1", "a/2", "a["}},
+		{persistent.ScanOptions{Prefix: []byte("a"), Start: []byte("a0")}, []string{"a[", "ab", "a\xff"}},
+		{persistent.ScanOptions{Prefix: []byte("a"), End: []byte("a0")}, []string{"a", "a*", "a/1", "a/2"}},
+		{persistent.ScanOptions{Prefix: []byte("a"), Limit: 2}, []string{"a", "a*"}},
+		{persistent.ScanOptions{Prefix: []byte("a/"), KeysOnly: true}, []string{"a/1", "a/2"}},
+	}
+
+	for _, tt := range tests {
+		keys := scanKeys(t, s, tt.opts, saved)
+		if fmt.Sprintf("%q", keys) != fmt.Sprintf("%q", tt.expected) {
+			t.Fatalf("Scan(%+v): expected %q, got %q", tt.opts, tt.expected, keys)
+		}
+	}
+
+	visited := 0
+	err = persistent.Scan(s, persistent.ScanOptions{}, func(k, v []byte) bool {
+		visited++
+		return visited < 3
+	})
+
+	if err != nil || visited != 3 {
+		t.Fatalf("expected Scan to stop after 3 keys, visited %d: %v", visited, err)
+	}
+
+	type pessoa struct {
+		Nome   *persistent.PersistentString
+		Filhos *persistent.PersistentSlice
+	}
+
+	for _, id := range []string{"pcarlos", "pana"} {
+		persistent.PersistStruct(id, &pessoa{
+			Nome:   persistent.NewPersistentString(id),
+			Filhos: &persistent.PersistentSlice{persistent.NewPersistentString("Ana")},
+		}, s, func(e error) {
+			if e != nil {
+				t.Fatal(e)
+			}
+		})
+	}
+
+	ids, err := persistent.ListStructIDs(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "a" comes from the keys a/1 and a/2 saved above
+	if fmt.Sprint(ids) != "[a pana pcarlos]" {
+		t.Fatalf("unexpected struct ids %q", ids)
+	}
+
+	fields, err := persistent.ListStructFields(s, "pcarlos")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(fields) != "[Filhos Nome]" {
+		t.Fatalf("unexpected fields %q", fields)
+	}
+}
+
+func testBatch(t *testing.T, s persistent.Storager) {
+	mustSave(t, s, []byte("batch/old"), []byte("v"))
+
+	// the keys share a struct id, for the storagers routing by it
+	err := persistent.Batch(s, []persistent.BatchOp{
+		{Key: []byte("batch/1"), Value: []byte("1")},
+		{Key: []byte("batch/2"), Value: []byte{}},
+		{Key: []byte("batch/old"), Delete: true},
+		{Key: []byte("batch/1"), Value: []byte("one")},
+	})
+	if errors.Is(err, persistent.ErrBatchUnsupported) {
+		t.Skip(err)
+	}
+
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	mustLoad(t, s, []byte("batch/1"), []byte("one"))
+	mustLoad(t, s, []byte("batch/2"), []byte{})
+	mustNotFind(t, s, []byte("batch/old"))
+
+	if err := persistent.Batch(s, nil); err != nil {
+		t.Fatalf("Batch(nil): %v", err)
+	}
+}
+
+func mustSwap(t *testing.T, s persistent.Storager, k, old, new []byte, expected bool) {
+	t.Helper()
+
+	swapped, err := persistent.CompareAndSwap(s, k, old, new)
+	if err != nil {
+		t.Fatalf("CompareAndSwap(%q, %q, %q): %v", k, old, new, err)
+	}
+
+	if swapped != expected {
+		t.Fatalf("CompareAndSwap(%q, %q, %q): expected swapped %v", k, old, new, expected)
+	}
+}
+
+func testCompareAndSwap(t *testing.T, s persistent.Storager) {
+	k := []byte("cas")
+
+	_, err := persistent.CompareAndSwap(s, k, nil, []byte("1"))
+	if errors.Is(err, persistent.ErrCompareAndSwapUnsupported) {
+		t.Skip(err)
+	}
+
+	if err != nil {
+		t.Fatalf("CompareAndSwap: %v", err)
+	}
+
+	mustLoad(t, s, k, []byte("1"))
+
+	mustSwap(t, s, k, nil, []byte("2"), false)
+	mustSwap(t, s, k, []byte("2"), []byte("3"), false)
+	mustLoad(t, s, k, []byte("1"))
+
+	mustSwap(t, s, k, []byte("1"), []byte{}, true)
+	mustLoad(t, s, k, []byte{})
+
+	mustSwap(t, s, k, nil, []byte("4"), false)
+	mustSwap(t, s, k, []byte{}, nil, true)
+	mustNotFind(t, s, k)
+
+	mustSwap(t, s, k, []byte{}, []byte("5"), false)
+	mustSwap(t, s, k, nil, nil, true)
+	mustNotFind(t, s, k)
+}
@@ -0,0 +1,13 @@
+package persistenttest
+
+import (
+	"testing"
+
+	"github.com/carlosmpv/persistent"
+)
+
+func TestMemoryStorager(t *testing.T) {
+	RunStoragerSuite(t, func(t *testing.T) persistent.Storager {
+		return persistent.NewMemoryStorager()
+	})
+}
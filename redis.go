@@ -0,0 +1,479 @@
+package persistent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisMaxBulkLen is the largest bulk string read from a reply, the default
+// proto-max-bulk-len of Redis.
+const redisMaxBulkLen = 512 << 20
+
+// RedisError is an error reply sent by the server.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (rc *redisConn) write(args [][]byte) {
+	fmt.Fprintf(rc.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(rc.w, "$%d\r\n", len(arg))
+		rc.w.Write(arg)
+		rc.w.WriteString("\r\n")
+	}
+}
+
+func (rc *redisConn) readLine() ([]byte, error) {
+	line, err := rc.r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed redis reply")
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// read returns a reply as a string, RedisError, int64, []byte (nil for a nil
+// bulk string) or []interface{}.
+func (rc *redisConn) read() (interface{}, error) {
+	line, err := rc.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return RedisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		l, err := strconv.Atoi(string(line[1:]))
+		if err != nil || l < 0 {
+			return []byte(nil), err
+		}
+
+		if l > redisMaxBulkLen {
+			return nil, fmt.Errorf("redis bulk string of %d bytes is over the limit of %d", l, redisMaxBulkLen)
+		}
+
+		dt := make([]byte, l+2)
+		if _, err := io.ReadFull(rc.r, dt); err != nil {
+			return nil, err
+		}
+
+		return dt[:l], nil
+	case '*':
+		l, err := strconv.Atoi(string(line[1:]))
+		if err != nil || l < 0 {
+			return []interface{}(nil), err
+		}
+
+		// grown as the elements arrive, the length is not trusted
+		arr := []interface{}{}
+		for i := 0; i < l; i++ {
+			elem, err := rc.read()
+			if err != nil {
+				return nil, err
+			}
+
+			arr = append(arr, elem)
+		}
+
+		return arr, nil
+	}
+
+	return nil, fmt.Errorf("unknown redis reply type %q", line[0])
+}
+
+// RedisStorager maps Save to SET and Load to GET on a Redis server, speaking
+// RESP2 over a pool of at most poolSize open connections. Calls wait for a
+// connection while they are all busy.
+type RedisStorager struct {
+	Addr        string
+	Username    string
+	Password    string
+	DB          int
+	DialTimeout time.Duration
+
+	// Timeout bounds every round trip to the server. Zero waits forever.
+	Timeout time.Duration
+
+	pool chan *redisConn
+
+	// conns holds a token per open connection in use
+	conns chan struct{}
+}
+
+func NewRedisStorager(addr string, poolSize int) *RedisStorager {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+
+	return &RedisStorager{
+		Addr:        addr,
+		DialTimeout: 5 * time.Second,
+		pool:        make(chan *redisConn, poolSize),
+		conns:       make(chan struct{}, poolSize),
+	}
+}
+
+func (rs *RedisStorager) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: rs.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", rs.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &redisConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+
+	cmds := [][][]byte{}
+	if rs.Password != "" && rs.Username != "" {
+		cmds = append(cmds, [][]byte{[]byte("AUTH"), []byte(rs.Username), []byte(rs.Password)})
+	} else if rs.Password != "" {
+		cmds = append(cmds, [][]byte{[]byte("AUTH"), []byte(rs.Password)})
+	}
+
+	if rs.DB != 0 {
+		cmds = append(cmds, [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(rs.DB))})
+	}
+
+	replies, err := rs.pipeline(ctx, rc, cmds)
+	if err == nil {
+		err = firstRedisError(replies)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+func (rs *RedisStorager) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case rs.conns <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case rc := <-rs.pool:
+		return rc, nil
+	default:
+	}
+
+	rc, err := rs.dial(ctx)
+	if err != nil {
+		<-rs.conns
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+func (rs *RedisStorager) put(rc *redisConn) {
+	select {
+	case rs.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+
+	<-rs.conns
+}
+
+// discard closes a connection left in an unknown state by a failed call.
+func (rs *RedisStorager) discard(rc *redisConn) {
+	rc.conn.Close()
+	<-rs.conns
+}
+
+func (rs *RedisStorager) pipeline(ctx context.Context, rc *redisConn, cmds [][][]byte) ([]interface{}, error) {
+	deadline := time.Time{}
+	if rs.Timeout > 0 {
+		deadline = time.Now().Add(rs.Timeout)
+	}
+
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+
+	rc.conn.SetDeadline(deadline)
+
+	// a cancelled context interrupts the round trip by expiring the deadline
+	if ctx.Done() != nil {
+		done, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				rc.conn.SetDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+	}
+
+	for _, args := range cmds {
+		rc.write(args)
+	}
+
+	if err := rc.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		reply, err := rc.read()
+		if err != nil {
+			return nil, err
+		}
+
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+// redisContextErr reports the failures caused by ctx as the context error.
+// The connection deadline may expire just before the context notices it.
+func redisContextErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var netErr net.Error
+	if d, ok := ctx.Deadline(); ok && errors.As(err, &netErr) && netErr.Timeout() && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+func firstRedisError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(RedisError); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pipeline sends every command on one connection before reading any reply.
+// Error replies are returned as RedisError values in the reply slice.
+func (rs *RedisStorager) Pipeline(cmds ...[][]byte) ([]interface{}, error) {
+	return rs.PipelineContext(context.Background(), cmds...)
+}
+
+// PipelineContext is Pipeline bounded by the deadline and cancellation of ctx.
+func (rs *RedisStorager) PipelineContext(ctx context.Context, cmds ...[][]byte) ([]interface{}, error) {
+	rc, err := rs.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := rs.pipeline(ctx, rc, cmds)
+	if err != nil {
+		rs.discard(rc)
+		return nil, redisContextErr(ctx, err)
+	}
+
+	rs.put(rc)
+	return replies, nil
+}
+
+// Do sends a single command and returns its reply, turning an error reply
+// into an error.
+func (rs *RedisStorager) Do(args ...[]byte) (interface{}, error) {
+	return rs.DoContext(context.Background(), args...)
+}
+
+// DoContext is Do bounded by the deadline and cancellation of ctx.
+func (rs *RedisStorager) DoContext(ctx context.Context, args ...[]byte) (interface{}, error) {
+	replies, err := rs.PipelineContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := firstRedisError(replies); err != nil {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+func (rs *RedisStorager) Save(k, v []byte) error {
+	return rs.SaveContext(context.Background(), k, v)
+}
+
+func (rs *RedisStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	_, err := rs.DoContext(ctx, []byte("SET"), k, v)
+	return err
+}
+
+func (rs *RedisStorager) Load(k []byte) ([]byte, error) {
+	return rs.LoadContext(context.Background(), k)
+}
+
+func (rs *RedisStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	reply, err := rs.DoContext(ctx, []byte("GET"), k)
+	if err != nil {
+		return nil, err
+	}
+
+	dt, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply to GET %q", k)
+	}
+
+	if dt == nil {
+		return nil, ErrNotFound
+	}
+
+	return dt, nil
+}
+
+func (rs *RedisStorager) Delete(k []byte) error {
+	return rs.DeleteContext(context.Background(), k)
+}
+
+func (rs *RedisStorager) DeleteContext(ctx context.Context, k []byte) error {
+	_, err := rs.DoContext(ctx, []byte("DEL"), k)
+	return err
+}
+
+func (rs *RedisStorager) Batch(ops []BatchOp) error {
+	return rs.BatchContext(context.Background(), ops)
+}
+
+// BatchContext sends the ops between MULTI and EXEC in a single pipeline.
+func (rs *RedisStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	cmds := [][][]byte{{[]byte("MULTI")}}
+	for _, op := range ops {
+		if op.Delete {
+			cmds = append(cmds, [][]byte{[]byte("DEL"), op.Key})
+		} else {
+			cmds = append(cmds, [][]byte{[]byte("SET"), op.Key, op.Value})
+		}
+	}
+	cmds = append(cmds, [][]byte{[]byte("EXEC")})
+
+	replies, err := rs.PipelineContext(ctx, cmds...)
+	if err != nil {
+		return err
+	}
+
+	if err := firstRedisError(replies); err != nil {
+		return err
+	}
+
+	executed, ok := replies[len(replies)-1].([]interface{})
+	if !ok || executed == nil {
+		return errors.New("redis transaction was aborted")
+	}
+
+	return firstRedisError(executed)
+}
+
+// redisGlobPrefix escapes the glob characters of prefix and matches every key
+// starting with it.
+func redisGlobPrefix(prefix []byte) []byte {
+	pattern := make([]byte, 0, len(prefix)+1)
+	for _, c := range prefix {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			pattern = append(pattern, '\\')
+		}
+		pattern = append(pattern, c)
+	}
+
+	return append(pattern, '*')
+}
+
+func (rs *RedisStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return rs.ScanContext(context.Background(), opts, fn)
+}
+
+// ScanContext lists the keys matching opts.Prefix with SCAN before sorting
+// them, as Redis returns keys in no particular order, and loads the values
+// selected with GET.
+func (rs *RedisStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	pattern := redisGlobPrefix(opts.Prefix)
+	seen := map[string]bool{}
+	keys := [][]byte{}
+
+	cursor := []byte("0")
+	for {
+		reply, err := rs.DoContext(ctx, []byte("SCAN"), cursor, []byte("MATCH"), pattern, []byte("COUNT"), []byte("1000"))
+		if err != nil {
+			return err
+		}
+
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return errors.New("unexpected reply to SCAN")
+		}
+
+		cursor, ok = page[0].([]byte)
+		found, isArray := page[1].([]interface{})
+		if !ok || !isArray {
+			return errors.New("unexpected reply to SCAN")
+		}
+
+		// SCAN may return a key more than once
+		for _, k := range found {
+			if k, ok := k.([]byte); ok && !seen[string(k)] {
+				seen[string(k)] = true
+				keys = append(keys, k)
+			}
+		}
+
+		if string(cursor) == "0" {
+			break
+		}
+	}
+
+	return scanKeys(keys, opts, func(k []byte) ([]byte, error) {
+		return rs.LoadContext(ctx, k)
+	}, fn)
+}
+
+// Close closes the idle connections of the pool.
+func (rs *RedisStorager) Close() error {
+	for {
+		select {
+		case rc := <-rs.pool:
+			rc.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package persistent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRedisServer is an in-process stand-in for Redis that understands the
+// commands issued by RedisStorager.
+type testRedisServer struct {
+	listener net.Listener
+	password string
+
+	lock  sync.Mutex
+	dbs   map[string]map[string][]byte
+	conns int
+}
+
+func newTestRedisServer(t *testing.T, password string) *testRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &testRedisServer{
+		listener: listener,
+		password: password,
+		dbs:      map[string]map[string][]byte{},
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			srv.lock.Lock()
+			srv.conns++
+			srv.lock.Unlock()
+
+			go srv.serve(conn)
+		}
+	}()
+
+	return srv
+}
+
+func (srv *testRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	authed := srv.password == ""
+	db := "0"
+
+	// the commands sent after MULTI, nil outside of a transaction
+	var queued [][][]byte
+
+	for {
+		cmd, err := rc.read()
+		if err != nil {
+			return
+		}
+
+		args := [][]byte{}
+		for _, arg := range cmd.([]interface{}) {
+			args = append(args, arg.([]byte))
+		}
+
+		srv.lock.Lock()
+		switch name := strings.ToUpper(string(args[0])); {
+		case name == "AUTH":
+			authed = string(args[len(args)-1]) == srv.password
+			if authed {
+				rc.w.WriteString("+OK\r\n")
+			} else {
+				rc.w.WriteString("-WRONGPASS invalid password\r\n")
+			}
+		case !authed:
+			rc.w.WriteString("-NOAUTH Authentication required.\r\n")
+		case name == "MULTI":
+			queued = [][][]byte{}
+			rc.w.WriteString("+OK\r\n")
+		case name == "EXEC":
+			fmt.Fprintf(rc.w, "*%d\r\n", len(queued))
+			for _, args := range queued {
+				srv.run(rc.w, &db, args)
+			}
+			queued = nil
+		case queued != nil:
+			queued = append(queued, args)
+			rc.w.WriteString("+QUEUED\r\n")
+		default:
+			srv.run(rc.w, &db, args)
+		}
+		srv.lock.Unlock()
+
+		if rc.r.Buffered() == 0 {
+			if err := rc.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// run executes a command on db, must be called with the lock held.
+func (srv *testRedisServer) run(w *bufio.Writer, db *string, args [][]byte) {
+	if srv.dbs[*db] == nil {
+		srv.dbs[*db] = map[string][]byte{}
+	}
+
+	switch name := strings.ToUpper(string(args[0])); {
+	case name == "SELECT":
+		*db = string(args[1])
+		w.WriteString("+OK\r\n")
+	case name == "SET":
+		srv.dbs[*db][string(args[1])] = args[2]
+		w.WriteString("+OK\r\n")
+	case name == "GET":
+		v, ok := srv.dbs[*db][string(args[1])]
+		if !ok {
+			w.WriteString("$-1\r\n")
+		} else {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+		}
+	case name == "DEL":
+		_, ok := srv.dbs[*db][string(args[1])]
+		delete(srv.dbs[*db], string(args[1]))
+		if ok {
+			w.WriteString(":1\r\n")
+		} else {
+			w.WriteString(":0\r\n")
+		}
+	case name == "SCAN":
+		// pages of two of the keys starting with the unescaped pattern,
+		// the cursor being the index of the next page
+		prefix := strings.TrimSuffix(string(args[3]), "*")
+		prefix = regexp.MustCompile(`(?s)\\(.)`).ReplaceAllString(prefix, "$1")
+
+		matched := []string{}
+		for k := range srv.dbs[*db] {
+			if strings.HasPrefix(k, prefix) {
+				matched = append(matched, k)
+			}
+		}
+		sort.Strings(matched)
+
+		cursor, _ := strconv.Atoi(string(args[1]))
+		if cursor > len(matched) {
+			cursor = len(matched)
+		}
+
+		page, next := matched[cursor:], "0"
+		if len(page) > 2 {
+			page, next = page[:2], strconv.Itoa(cursor+2)
+		}
+
+		fmt.Fprintf(w, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(next), next, len(page))
+		for _, k := range page {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(k), k)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", name)
+	}
+}
+
+func TestRedisStorager(t *testing.T) {
+	srv := newTestRedisServer(t, "secret")
+
+	storager := NewRedisStorager(srv.listener.Addr().String(), 4)
+	storager.Password = "secret"
+	storager.DB = 3
+	defer storager.Close()
+
+	k := []byte{PersistentInt64Prefix, 'k', '\r', '\n', 0}
+	if err := storager.Save(k, []byte("value\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	dt, err := storager.Load(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(dt) != "value\r\n" {
+		t.Fatalf("expected value, got %q", dt)
+	}
+
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	srv.lock.Lock()
+	_, ok := srv.dbs["3"][string(k)]
+	conns := srv.conns
+	srv.lock.Unlock()
+
+	if !ok {
+		t.Fatal("value was not saved in the selected database")
+	}
+
+	if conns != 1 {
+		t.Fatalf("expected a single pooled connection, got %d", conns)
+	}
+
+	if _, err := storager.Do([]byte("NOPE")); err == nil {
+		t.Fatal("expected an error reply")
+	}
+
+	replies, err := storager.Pipeline(
+		[][]byte{[]byte("SET"), []byte("a"), []byte("1")},
+		[][]byte{[]byte("GET"), []byte("a")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replies[0] != "OK" || string(replies[1].([]byte)) != "1" {
+		t.Fatalf("unexpected replies %v", replies)
+	}
+
+	if err := storager.Delete(k); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load(k); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the key to be deleted, got %v", err)
+	}
+}
+
+func TestRedisStoragerAuth(t *testing.T) {
+	srv := newTestRedisServer(t, "secret")
+
+	storager := NewRedisStorager(srv.listener.Addr().String(), 1)
+	storager.Password = "wrong"
+
+	var redisErr RedisError
+	if err := storager.Save([]byte("a"), []byte("1")); !errors.As(err, &redisErr) {
+		t.Fatalf("expected an auth error, got %v", err)
+	}
+}
+
+func TestRedisStoragerStruct(t *testing.T) {
+	srv := newTestRedisServer(t, "")
+
+	storager := NewRedisStorager(srv.listener.Addr().String(), 8)
+	defer storager.Close()
+
+	type pessoa struct {
+		Nome   *PersistentString
+		Filhos *PersistentSlice
+	}
+
+	pCarlos := &pessoa{
+		Nome:   NewPersistentString("Carlos"),
+		Filhos: &PersistentSlice{NewPersistentString("Ana"), NewPersistentString("Rui")},
+	}
+
+	PersistStruct("pcarlos", pCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	nCarlos := &pessoa{}
+	RestoreStruct("pcarlos", nCarlos, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	if *pCarlos.Nome != *nCarlos.Nome || len(*nCarlos.Filhos) != 2 {
+		t.Fail()
+	}
+}
+
+func TestRedisStoragerContext(t *testing.T) {
+	// a server that accepts connections and never replies
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conns := []net.Conn{}
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	storager := NewRedisStorager(listener.Addr().String(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := storager.LoadContext(ctx, []byte("a")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	if err := storager.SaveContext(ctx, []byte("a"), []byte("b")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled save, got %v", err)
+	}
+}
+
+func TestRedisStoragerPoolSize(t *testing.T) {
+	srv := newTestRedisServer(t, "")
+
+	storager := NewRedisStorager(srv.listener.Addr().String(), 2)
+	defer storager.Close()
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 10; i++ {
+				if err := storager.Save([]byte(fmt.Sprint(g)), []byte(fmt.Sprint(i))); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if srv.conns > 2 {
+		t.Fatalf("expected at most 2 connections, got %d", srv.conns)
+	}
+}
+
+func TestRedisStoragerBulkLimit(t *testing.T) {
+	// a server announcing a huge value
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("$4294967296\r\n"))
+	}()
+
+	storager := NewRedisStorager(listener.Addr().String(), 1)
+	storager.Timeout = time.Second
+
+	if _, err := storager.Load([]byte("a")); err == nil || !strings.Contains(err.Error(), "over the limit") {
+		t.Fatalf("expected the bulk string to be rejected, got %v", err)
+	}
+}
+
+func TestRedisStoragerScan(t *testing.T) {
+	srv := newTestRedisServer(t, "")
+
+	storager := NewRedisStorager(srv.listener.Addr().String(), 1)
+	defer storager.Close()
+
+	// glob characters in the prefix match themselves
+	for _, k := range []string{"a*1", "a*2", "a*3", "a*4", "a*5", "ab", "b"} {
+		storager.Save([]byte(k), []byte("v"+k))
+	}
+
+	keys := ""
+	err := storager.Scan(ScanOptions{Prefix: []byte("a*"), Start: []byte("a*2")}, func(k, v []byte) bool {
+		if string(v) != "v"+string(k) {
+			t.Fatalf("unexpected value of %q: %q", k, v)
+		}
+
+		keys += string(k) + " "
+		return true
+	})
+
+	if err != nil || keys != "a*2 a*3 a*4 a*5 " {
+		t.Fatalf("unexpected keys %q, %v", keys, err)
+	}
+}
+
+func TestRedisStoragerBatch(t *testing.T) {
+	srv := newTestRedisServer(t, "")
+
+	storager := NewRedisStorager(srv.listener.Addr().String(), 1)
+	defer storager.Close()
+
+	storager.Save([]byte("a"), []byte("1"))
+
+	err := storager.Batch([]BatchOp{
+		{Key: []byte("a"), Delete: true},
+		{Key: []byte("b"), Value: []byte("2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected b to be saved, got %q, %v", dt, err)
+	}
+}
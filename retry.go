@@ -0,0 +1,221 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Retryable is the default classifier of RetryingStorager. It retries every
+// error except those a new attempt would not fix: missing keys, corrupted or
+// oversized values, closed storagers, cancelled calls, and the client errors
+// of the HTTP and S3 storagers, 4xx statuses other than 408 and 429.
+func Retryable(err error) bool {
+	var corruption *CorruptionError
+	var httpErr *HTTPError
+	var s3Err *S3Error
+
+	switch {
+	case errors.Is(err, ErrNotFound), errors.As(err, &corruption), errors.Is(err, ErrValueTooLarge),
+		errors.Is(err, os.ErrClosed), errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &httpErr):
+		return !permanentStatus(httpErr.StatusCode)
+	case errors.As(err, &s3Err):
+		return !permanentStatus(s3Err.StatusCode)
+	}
+
+	return true
+}
+
+// permanentStatus tells whether an HTTP status reports a request that fails
+// the same way however many times it is sent.
+func permanentStatus(status int) bool {
+	return status >= 400 && status < 500 &&
+		status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// RetryingStorager retries failed Saves and Loads of the wrapped Storager
+// with exponential backoff and jitter, until MaxAttempts attempts were made
+// or MaxElapsed passed. Classifier decides which errors are worth a new
+// attempt; missing keys are never retried. The context taking methods stop
+// retrying once their context is done, or when the next attempt would start
+// after its deadline.
+type RetryingStorager struct {
+	Storager Storager
+
+	MaxAttempts    int
+	MaxElapsed     time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter randomizes each backoff by up to this fraction of it.
+	Jitter float64
+
+	Classifier func(error) bool
+
+	sleep func(time.Duration)
+}
+
+func NewRetryingStorager(s Storager) *RetryingStorager {
+	return &RetryingStorager{
+		Storager:       s,
+		MaxAttempts:    5,
+		MaxElapsed:     30 * time.Second,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		Classifier:     Retryable,
+	}
+}
+
+func (rs *RetryingStorager) backoff(attempt int) time.Duration {
+	d := float64(rs.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= rs.Multiplier
+		if rs.MaxBackoff > 0 && d > float64(rs.MaxBackoff) {
+			d = float64(rs.MaxBackoff)
+			break
+		}
+	}
+
+	if rs.Jitter > 0 {
+		d += d * rs.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+func (rs *RetryingStorager) retry(ctx context.Context, op func() error) error {
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrDeleteUnsupported) ||
+			errors.Is(err, ErrScanUnsupported) || errors.Is(err, ErrBatchUnsupported) {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if rs.Classifier != nil && !rs.Classifier(err) {
+			return err
+		}
+
+		if rs.MaxAttempts > 0 && attempt >= rs.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := rs.backoff(attempt)
+		if rs.MaxElapsed > 0 && time.Since(start)+wait > rs.MaxElapsed {
+			return fmt.Errorf("giving up after %d attempts in %s: %w", attempt, time.Since(start), err)
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("giving up after %d attempts, the deadline is too close: %w", attempt, err)
+		}
+
+		if rs.sleep != nil {
+			rs.sleep(wait)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (rs *RetryingStorager) Save(k, v []byte) error {
+	return rs.SaveContext(context.Background(), k, v)
+}
+
+func (rs *RetryingStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	return rs.retry(ctx, func() error {
+		return saveContext(ctx, rs.Storager, k, v)
+	})
+}
+
+func (rs *RetryingStorager) Load(k []byte) ([]byte, error) {
+	return rs.LoadContext(context.Background(), k)
+}
+
+func (rs *RetryingStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	var dt []byte
+
+	err := rs.retry(ctx, func() error {
+		var err error
+		dt, err = loadContext(ctx, rs.Storager, k)
+		return err
+	})
+
+	return dt, err
+}
+
+func (rs *RetryingStorager) wrapped() []Storager {
+	return []Storager{rs.Storager}
+}
+
+func (rs *RetryingStorager) Delete(k []byte) error {
+	return rs.DeleteContext(context.Background(), k)
+}
+
+// DeleteContext never retries ErrDeleteUnsupported.
+func (rs *RetryingStorager) DeleteContext(ctx context.Context, k []byte) error {
+	return rs.retry(ctx, func() error {
+		return DeleteContext(ctx, rs.Storager, k)
+	})
+}
+
+func (rs *RetryingStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return rs.ScanContext(context.Background(), opts, fn)
+}
+
+// ScanContext resumes a failed scan after the last key visited, so fn never
+// sees a key twice.
+func (rs *RetryingStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	visited, stopped := 0, false
+
+	return rs.retry(ctx, func() error {
+		if stopped || opts.Limit > 0 && visited >= opts.Limit {
+			return nil
+		}
+
+		resumed := opts
+		if opts.Limit > 0 {
+			resumed.Limit = opts.Limit - visited
+		}
+
+		return ScanContext(ctx, rs.Storager, resumed, func(k, v []byte) bool {
+			visited++
+			opts.Start = append(append([]byte(nil), k...), 0)
+
+			stopped = !fn(k, v)
+			return !stopped
+		})
+	})
+}
+
+func (rs *RetryingStorager) Batch(ops []BatchOp) error {
+	return rs.BatchContext(context.Background(), ops)
+}
+
+// BatchContext retries the whole batch, which is safe as it was either
+// fully applied or not at all.
+func (rs *RetryingStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	return rs.retry(ctx, func() error {
+		return BatchContext(ctx, rs.Storager, ops)
+	})
+}
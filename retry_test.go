@@ -0,0 +1,256 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type flakyStorager struct {
+	Storager
+	failures int
+	calls    int
+}
+
+func (fs *flakyStorager) Save(k, v []byte) error {
+	fs.calls++
+	if fs.calls <= fs.failures {
+		return errors.New("connection reset")
+	}
+
+	return fs.Storager.Save(k, v)
+}
+
+func (fs *flakyStorager) Load(k []byte) ([]byte, error) {
+	fs.calls++
+	if fs.calls <= fs.failures {
+		return nil, errors.New("connection reset")
+	}
+
+	return fs.Storager.Load(k)
+}
+
+func TestRetryingStorager(t *testing.T) {
+	backend := &flakyStorager{Storager: NewMemoryStorager(), failures: 3}
+
+	waits := []time.Duration{}
+	storager := NewRetryingStorager(backend)
+	storager.Jitter = 0
+	storager.sleep = func(d time.Duration) {
+		waits = append(waits, d)
+	}
+
+	if err := storager.Save([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if backend.calls != 4 || len(waits) != 3 {
+		t.Fatalf("expected 4 attempts, got %d", backend.calls)
+	}
+
+	if waits[0] != 50*time.Millisecond || waits[1] != 100*time.Millisecond || waits[2] != 200*time.Millisecond {
+		t.Fatalf("unexpected backoffs %v", waits)
+	}
+
+	backend.calls, backend.failures = 0, 10
+	if err := storager.Save([]byte("a"), []byte("1")); err == nil {
+		t.Fatal("expected the attempts to run out")
+	}
+
+	if backend.calls != storager.MaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", storager.MaxAttempts, backend.calls)
+	}
+
+	backend.calls, backend.failures = 0, 0
+	if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) || backend.calls != 1 {
+		t.Fatalf("missing keys must not be retried, got %v after %d attempts", err, backend.calls)
+	}
+}
+
+func TestRetryingStoragerClassifier(t *testing.T) {
+	backend := &flakyStorager{Storager: NewMemoryStorager(), failures: 3}
+
+	storager := NewRetryingStorager(backend)
+	storager.sleep = func(time.Duration) {}
+	storager.Classifier = func(err error) bool {
+		return false
+	}
+
+	if err := storager.Save([]byte("a"), []byte("1")); err == nil || backend.calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", backend.calls)
+	}
+
+	var corruption error = &CorruptionError{Key: []byte("a")}
+	if Retryable(corruption) || Retryable(ErrNotFound) || !Retryable(errors.New("timeout")) {
+		t.Fail()
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("connection reset"), true},
+		{ErrNotFound, false},
+		{&CorruptionError{Key: []byte("a")}, false},
+		{fmt.Errorf("load: %w", ErrValueTooLarge), false},
+		{fmt.Errorf("save: %w", os.ErrClosed), false},
+		{fmt.Errorf("save: %w", context.Canceled), false},
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("GET: %w", &HTTPError{StatusCode: http.StatusUnauthorized}), false},
+		{&HTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		{&HTTPError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&S3Error{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, false},
+		{&S3Error{StatusCode: http.StatusRequestTimeout, Code: "RequestTimeout"}, true},
+		{&S3Error{StatusCode: http.StatusInternalServerError, Code: "InternalError"}, true},
+	}
+
+	for _, test := range tests {
+		if Retryable(test.err) != test.retryable {
+			t.Errorf("Retryable(%v) = %v", test.err, !test.retryable)
+		}
+	}
+}
+
+func TestRetryingStoragerUnauthorized(t *testing.T) {
+	handler := NewStoragerHandler(NewMemoryStorager())
+	handler.Token = "secret"
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	storager := NewRetryingStorager(NewHTTPStorager(srv.URL + "/"))
+	storager.sleep = func(time.Duration) {}
+
+	var httpErr *HTTPError
+	err := storager.Save([]byte("a"), []byte("1"))
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+
+	if requests != 1 {
+		t.Fatalf("expected a single request, got %d", requests)
+	}
+}
+
+func TestRetryingStoragerElapsed(t *testing.T) {
+	backend := &flakyStorager{Storager: NewMemoryStorager(), failures: 100}
+
+	storager := NewRetryingStorager(backend)
+	storager.MaxAttempts = 0
+	storager.MaxElapsed = 100 * time.Millisecond
+	storager.InitialBackoff = 10 * time.Millisecond
+	storager.Jitter = 0
+
+	start := time.Now()
+	if _, err := storager.Load([]byte("a")); err == nil {
+		t.Fatal("expected the time to run out")
+	}
+
+	// waits of 10, 20 and 40ms, the next one would pass MaxElapsed
+	if time.Since(start) > storager.MaxElapsed || backend.calls != 4 {
+		t.Fatalf("took %s in %d attempts", time.Since(start), backend.calls)
+	}
+}
+
+func TestRetryingStoragerJitter(t *testing.T) {
+	storager := NewRetryingStorager(nil)
+
+	for i := 0; i < 100; i++ {
+		d := storager.backoff(3)
+		if d < 160*time.Millisecond || d > 240*time.Millisecond {
+			t.Fatalf("backoff %s out of the jitter range", d)
+		}
+	}
+
+	storager.Jitter = 0
+	if d := storager.backoff(20); d != storager.MaxBackoff {
+		t.Fatalf("expected the backoff to be capped, got %s", d)
+	}
+}
+
+func TestRetryingStoragerContext(t *testing.T) {
+	backend := &flakyStorager{Storager: NewMemoryStorager(), failures: 100}
+
+	storager := NewRetryingStorager(backend)
+	storager.InitialBackoff = time.Second
+	storager.Jitter = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := storager.SaveContext(ctx, []byte("a"), []byte("b")); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	// the first backoff already passes the deadline
+	if time.Since(start) > 50*time.Millisecond || backend.calls != 1 {
+		t.Fatalf("took %s in %d attempts", time.Since(start), backend.calls)
+	}
+
+	storager.InitialBackoff = 10 * time.Millisecond
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(25*time.Millisecond, cancel)
+
+	if _, err := storager.LoadContext(ctx, []byte("a")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled load, got %v", err)
+	}
+}
+
+// brokenScanner fails the scans once they visited after keys.
+type brokenScanner struct {
+	*MemoryStorager
+	after int
+}
+
+func (bs *brokenScanner) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	visited := 0
+	stopped := false
+
+	err := bs.MemoryStorager.Scan(opts, func(k, v []byte) bool {
+		if visited == bs.after {
+			stopped = true
+			return false
+		}
+
+		visited++
+		return fn(k, v)
+	})
+
+	if stopped {
+		return errors.New("connection reset")
+	}
+
+	return err
+}
+
+func TestRetryingStoragerScan(t *testing.T) {
+	backend := &brokenScanner{MemoryStorager: NewMemoryStorager(), after: 2}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		backend.Save([]byte(k), []byte(k))
+	}
+
+	storager := NewRetryingStorager(backend)
+	storager.sleep = func(time.Duration) {}
+
+	keys := ""
+	err := storager.Scan(ScanOptions{Limit: 5}, func(k, v []byte) bool {
+		keys += string(k)
+		return true
+	})
+
+	if err != nil || keys != "abcde" {
+		t.Fatalf("expected the scan to resume after the last key, got %q, %v", keys, err)
+	}
+}
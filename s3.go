@@ -0,0 +1,380 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Error is an error response of an S3 compatible server.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// S3Storager stores every key as an object of an S3 compatible bucket, below
+// Prefix. Requests are signed with AWS signature version 4.
+type S3Storager struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// VirtualHosted addresses the bucket as a subdomain of the endpoint
+	// instead of the first path segment.
+	VirtualHosted bool
+
+	Client *http.Client
+}
+
+func NewS3Storager(endpoint, region, bucket, accessKey, secretKey string) *S3Storager {
+	return &S3Storager{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    http.DefaultClient,
+	}
+}
+
+func isS3Safe(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '/' || c == '~'
+}
+
+// s3EmptyKeyName is the object name of the empty key. It is not the escaped
+// form of any other key.
+const s3EmptyKeyName = "%empty"
+
+// escapeS3Key keeps letters, digits and - _ ~ and replaces the other bytes,
+// such as the type prefixes, with %xx, so object names stay valid and
+// readable. Slashes and dots are replaced too, so that a key is a single path
+// segment and never a . or .. one.
+func escapeS3Key(k []byte) string {
+	if len(k) == 0 {
+		return s3EmptyKeyName
+	}
+
+	b := new(strings.Builder)
+	for _, c := range k {
+		if isS3Safe(c) && c != '/' && c != '.' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(b, "%%%02x", c)
+		}
+	}
+
+	return b.String()
+}
+
+func unescapeS3Key(name string) ([]byte, error) {
+	k := []byte{}
+	if name == s3EmptyKeyName {
+		return k, nil
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			k = append(k, name[i])
+			continue
+		}
+
+		if i+2 >= len(name) {
+			return nil, fmt.Errorf("%s is not an escaped key", name)
+		}
+
+		c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an escaped key", name)
+		}
+
+		k = append(k, byte(c))
+		i += 2
+	}
+
+	return k, nil
+}
+
+// s3URIEncode encodes a string as required by the canonical request of
+// signature version 4.
+func s3URIEncode(s string, encodeSlash bool) string {
+	b := new(strings.Builder)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isS3Safe(c) && (c != '/' || !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func (ss *S3Storager) objectURL(path string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(ss.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if ss.VirtualHosted {
+		u.Host = ss.Bucket + "." + u.Host
+	} else {
+		path = ss.Bucket + "/" + path
+	}
+
+	u.Path = "/" + path
+	u.RawPath = "/" + s3URIEncode(path, false)
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
+func hmacSHA256(key []byte, dt string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(dt))
+	return h.Sum(nil)
+}
+
+func sha256Hex(dt []byte) string {
+	h := sha256.Sum256(dt)
+	return hex.EncodeToString(h[:])
+}
+
+// signS3 adds the signature version 4 Authorization header to req. The host,
+// range, content-type and every x-amz-* header are signed.
+func signS3(req *http.Request, payloadHash, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := new(strings.Builder)
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+	sort.Strings(queryKeys)
+
+	canonicalQuery := []string{}
+	for _, k := range queryKeys {
+		for _, v := range query[k] {
+			canonicalQuery = append(canonicalQuery, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+}
+
+func (ss *S3Storager) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := ss.objectURL(path, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if ss.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", ss.SessionToken)
+	}
+
+	signS3(req, sha256Hex(body), ss.Region, ss.AccessKey, ss.SecretKey, time.Now())
+
+	res, err := ss.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+
+		s3Err := &S3Error{StatusCode: res.StatusCode}
+		dt, _ := io.ReadAll(res.Body)
+		xml.Unmarshal(dt, s3Err)
+
+		if s3Err.Code == "" {
+			s3Err.Code = http.StatusText(res.StatusCode)
+		}
+
+		return nil, s3Err
+	}
+
+	return res, nil
+}
+
+func (ss *S3Storager) Save(k, v []byte) error {
+	return ss.SaveContext(context.Background(), k, v)
+}
+
+func (ss *S3Storager) SaveContext(ctx context.Context, k, v []byte) error {
+	res, err := ss.do(ctx, http.MethodPut, ss.Prefix+escapeS3Key(k), nil, v)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(io.Discard, res.Body)
+	return err
+}
+
+func (ss *S3Storager) Load(k []byte) ([]byte, error) {
+	return ss.LoadContext(context.Background(), k)
+}
+
+func (ss *S3Storager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	res, err := ss.do(ctx, http.MethodGet, ss.Prefix+escapeS3Key(k), nil, nil)
+	if s3Err, ok := err.(*S3Error); ok && s3Err.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return io.ReadAll(res.Body)
+}
+
+func (ss *S3Storager) Delete(k []byte) error {
+	return ss.DeleteContext(context.Background(), k)
+}
+
+func (ss *S3Storager) DeleteContext(ctx context.Context, k []byte) error {
+	res, err := ss.do(ctx, http.MethodDelete, ss.Prefix+escapeS3Key(k), nil, nil)
+	if s3Err, ok := err.(*S3Error); ok && s3Err.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(io.Discard, res.Body)
+	return err
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (ss *S3Storager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return ss.ScanContext(context.Background(), opts, fn)
+}
+
+// ScanContext lists the objects below opts.Prefix with ListObjectsV2. Object
+// names are ordered by their escaped form, so every page is read before the
+// keys are sorted and their values loaded.
+func (ss *S3Storager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	keys := [][]byte{}
+
+	prefix := ss.Prefix
+	if len(opts.Prefix) > 0 {
+		prefix += escapeS3Key(opts.Prefix)
+	}
+
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+	}
+
+	for {
+		res, err := ss.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return err
+		}
+
+		list := &s3ListResult{}
+		err = xml.NewDecoder(res.Body).Decode(list)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, object := range list.Contents {
+			k, err := unescapeS3Key(strings.TrimPrefix(object.Key, ss.Prefix))
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, k)
+		}
+
+		if !list.IsTruncated {
+			break
+		}
+
+		query.Set("continuation-token", list.NextContinuationToken)
+	}
+
+	return scanKeys(keys, opts, func(k []byte) ([]byte, error) {
+		return ss.LoadContext(ctx, k)
+	}, fn)
+}
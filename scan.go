@@ -0,0 +1,258 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+)
+
+// ErrScanUnsupported is returned when scanning a Storager that does not
+// implement Scanner.
+var ErrScanUnsupported = errors.New("storager does not support Scan")
+
+// ScanOptions selects the keys visited by a Scan.
+type ScanOptions struct {
+	// Prefix restricts the scan to the keys starting with it.
+	Prefix []byte
+
+	// Start and End restrict the scan to the keys in [Start, End). A nil
+	// bound leaves that side open.
+	Start []byte
+	End   []byte
+
+	// Limit stops the scan after that many keys. Zero means no limit.
+	Limit int
+
+	// KeysOnly lets the Storager skip reading values, fn is then given nil
+	// values.
+	KeysOnly bool
+}
+
+// Scanner is implemented by the Storagers able to iterate over their keys.
+// Scan calls fn for every key selected by opts, in ascending byte order,
+// until fn returns false. fn must not write to the Storager.
+type Scanner interface {
+	Scan(opts ScanOptions, fn func(k, v []byte) bool) error
+}
+
+// ContextScanner is a Scanner whose calls honor the cancellation and
+// deadline of a context.
+type ContextScanner interface {
+	ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error
+}
+
+// Scan iterates over the keys of s selected by opts.
+func Scan(s Storager, opts ScanOptions, fn func(k, v []byte) bool) error {
+	return ScanContext(context.Background(), s, opts, fn)
+}
+
+// ScanContext iterates over the keys of s selected by opts. A Scanner that
+// takes no context is stopped before the first key visited once ctx is done.
+func ScanContext(ctx context.Context, s Storager, opts ScanOptions, fn func(k, v []byte) bool) error {
+	if cs, ok := s.(ContextScanner); ok {
+		return cs.ScanContext(ctx, opts, fn)
+	}
+
+	sc, ok := s.(Scanner)
+	if !ok {
+		return ErrScanUnsupported
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var ctxErr error
+	err := sc.Scan(opts, func(k, v []byte) bool {
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			return false
+		}
+
+		return fn(k, v)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return ctxErr
+}
+
+// prefixEnd returns the first key after every key starting with prefix, or
+// nil when there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// bounds merges Prefix, Start and End into a single [start, end) range.
+func (opts ScanOptions) bounds() (start, end []byte) {
+	start, end = opts.Start, opts.End
+
+	if len(opts.Prefix) > 0 {
+		if start == nil || bytes.Compare(start, opts.Prefix) < 0 {
+			start = opts.Prefix
+		}
+
+		if pEnd := prefixEnd(opts.Prefix); pEnd != nil && (end == nil || bytes.Compare(pEnd, end) < 0) {
+			end = pEnd
+		}
+	}
+
+	return start, end
+}
+
+// contains reports whether opts selects k.
+func (opts ScanOptions) contains(k []byte) bool {
+	start, end := opts.bounds()
+	return bytes.HasPrefix(k, opts.Prefix) &&
+		(start == nil || bytes.Compare(k, start) >= 0) &&
+		(end == nil || bytes.Compare(k, end) < 0)
+}
+
+// scanKeys is Scan for the storagers that can list their keys in no
+// particular order. Values are loaded one at a time and keys removed since
+// they were listed are skipped.
+func scanKeys(keys [][]byte, opts ScanOptions, load func([]byte) ([]byte, error), fn func(k, v []byte) bool) error {
+	selected := keys[:0]
+	for _, k := range keys {
+		if opts.contains(k) {
+			selected = append(selected, k)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return bytes.Compare(selected[i], selected[j]) < 0
+	})
+
+	visited := 0
+	for _, k := range selected {
+		if opts.Limit > 0 && visited >= opts.Limit {
+			break
+		}
+
+		var v []byte
+		if !opts.KeysOnly {
+			var err error
+			v, err = load(k)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		visited++
+		if !fn(k, v) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// scanValues scans s handing the values to decode before fn, stopping at the
+// first value decode fails on.
+func scanValues(ctx context.Context, s Storager, opts ScanOptions, decode func(k, dt []byte) ([]byte, error), fn func(k, v []byte) bool) error {
+	var decodeErr error
+
+	err := ScanContext(ctx, s, opts, func(k, dt []byte) bool {
+		if opts.KeysOnly {
+			return fn(k, nil)
+		}
+
+		v, err := decode(k, dt)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+
+		return fn(k, v)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return decodeErr
+}
+
+// ListStructIDs returns the ids of the structs persisted with PersistStruct
+// on s, sorted. Keys saved outside of PersistStruct that contain a '/' are
+// reported as well.
+func ListStructIDs(s Storager) ([]string, error) {
+	return ListStructIDsContext(context.Background(), s)
+}
+
+func ListStructIDsContext(ctx context.Context, s Storager) ([]string, error) {
+	seen := map[string]bool{}
+	ids := []string{}
+
+	err := ScanContext(ctx, s, ScanOptions{KeysOnly: true}, func(k, v []byte) bool {
+		k = stripPrefixes(k)
+		if i := bytes.IndexByte(k, '/'); i >= 0 && !seen[string(k[:i])] {
+			seen[string(k[:i])] = true
+			ids = append(ids, string(k[:i]))
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ListStructFields returns the names of the fields persisted for the struct
+// id, sorted.
+func ListStructFields(s Storager, id string) ([]string, error) {
+	return ListStructFieldsContext(context.Background(), s, id)
+}
+
+func ListStructFieldsContext(ctx context.Context, s Storager, id string) ([]string, error) {
+	prefixes := [][]byte{
+		// slices are found by their length, which they have even when empty
+		append([]byte{PersistentUint64Prefix, 'l', PersistentSlicePrefix}, id+"/"...),
+	}
+
+	for prefix := range prefixNames {
+		if prefix != PersistentUndefinedPrefix && prefix != PersistentSlicePrefix {
+			prefixes = append(prefixes, append([]byte{prefix}, id+"/"...))
+		}
+	}
+
+	seen := map[string]bool{}
+	fields := []string{}
+
+	for _, prefix := range prefixes {
+		err := ScanContext(ctx, s, ScanOptions{Prefix: prefix, KeysOnly: true}, func(k, v []byte) bool {
+			field := string(k[len(prefix):])
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+
+			return true
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(fields)
+	return fields, nil
+}
@@ -0,0 +1,100 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix, end []byte
+	}{
+		{[]byte("a"), []byte("b")},
+		{[]byte{'a', 0xff}, []byte("b")},
+		{[]byte{0xff, 0xff}, nil},
+		{[]byte{}, nil},
+	}
+
+	for _, tt := range tests {
+		if end := prefixEnd(tt.prefix); !bytes.Equal(end, tt.end) || (end == nil) != (tt.end == nil) {
+			t.Fatalf("prefixEnd(%q): expected %q, got %q", tt.prefix, tt.end, end)
+		}
+	}
+}
+
+func TestScanUnsupported(t *testing.T) {
+	storager := &testStorager{map[string][]byte{}}
+
+	if err := Scan(storager, ScanOptions{}, func(k, v []byte) bool { return true }); !errors.Is(err, ErrScanUnsupported) {
+		t.Fatalf("expected ErrScanUnsupported, got %v", err)
+	}
+
+	if _, err := ListStructIDs(NewCachedStorager(storager, 10, 0)); !errors.Is(err, ErrScanUnsupported) {
+		t.Fatalf("expected a wrapper to report ErrScanUnsupported, got %v", err)
+	}
+}
+
+func TestScanContext(t *testing.T) {
+	storager := NewMemoryStorager()
+	for i := 0; i < 10; i++ {
+		storager.Save([]byte(fmt.Sprint(i)), nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	visited := 0
+	err := ScanContext(ctx, storager, ScanOptions{}, func(k, v []byte) bool {
+		visited++
+		if visited == 3 {
+			cancel()
+		}
+		return true
+	})
+
+	if !errors.Is(err, context.Canceled) || visited != 3 {
+		t.Fatalf("expected the scan to stop after 3 keys, visited %d: %v", visited, err)
+	}
+}
+
+func TestListStructFields(t *testing.T) {
+	storager := NewMemoryStorager()
+
+	type person struct {
+		Name *PersistentString
+		Tags *PersistentSlice
+	}
+
+	PersistStruct("p", &person{
+		Name: NewPersistentString("Carlos"),
+		Tags: &PersistentSlice{},
+	}, storager, func(e error) {
+		if e != nil {
+			t.Fatal(e)
+		}
+	})
+
+	// another id sharing the prefix of p
+	NewPersistentString("x").Persist(storager, []byte("pp/Name"))
+
+	fields, err := ListStructFields(storager, "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(fields) != "[Name Tags]" {
+		t.Fatalf("unexpected fields %q", fields)
+	}
+
+	ids, err := ListStructIDs(storager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(ids) != "[p pp]" {
+		t.Fatalf("unexpected ids %q", ids)
+	}
+}
@@ -0,0 +1,242 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+// ShardedStorager routes every key to one of several Storagers using a
+// consistent hash ring with vnodes points per shard, so adding or removing a
+// shard only moves the keys of the ring segments it owns.
+//
+// With RouteByStructID set, keys are routed by the struct id used with
+// PersistStruct, the part of the key before the first '/', so every field of
+// a struct, slice elements included, lands on the same shard.
+type ShardedStorager struct {
+	RouteByStructID bool
+
+	shards map[string]Storager
+	ring   []ringPoint
+}
+
+func NewShardedStorager(shards map[string]Storager, vnodes int) *ShardedStorager {
+	ss := &ShardedStorager{shards: shards}
+
+	for name := range shards {
+		for v := 0; v < vnodes; v++ {
+			ss.ring = append(ss.ring, ringPoint{
+				hash:  ringHash([]byte(fmt.Sprintf("%s#%d", name, v))),
+				shard: name,
+			})
+		}
+	}
+
+	sort.Slice(ss.ring, func(i, j int) bool {
+		if ss.ring[i].hash == ss.ring[j].hash {
+			return ss.ring[i].shard < ss.ring[j].shard
+		}
+		return ss.ring[i].hash < ss.ring[j].hash
+	})
+
+	return ss
+}
+
+func ringHash(k []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(k)
+
+	// fnv alone clusters similar keys, mix the bits
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func isPersistentPrefix(c byte) bool {
+	return c <= PersistentBytePrefix
+}
+
+// stripPrefixes strips the type prefixes and the slice metadata markers from
+// a key.
+func stripPrefixes(k []byte) []byte {
+	for len(k) > 0 {
+		if isPersistentPrefix(k[0]) {
+			k = k[1:]
+		} else if len(k) > 1 && (k[0] == 'l' || k[0] == 't') && isPersistentPrefix(k[1]) {
+			k = k[1:]
+		} else {
+			break
+		}
+	}
+
+	return k
+}
+
+// structID returns what comes before the first '/' of a key stripped of its
+// prefixes. Keys written outside of PersistStruct have no '/' and are
+// returned whole.
+func structID(k []byte) []byte {
+	k = stripPrefixes(k)
+	if i := bytes.IndexByte(k, '/'); i >= 0 {
+		return k[:i]
+	}
+
+	return k
+}
+
+// Shard returns the name of the shard that owns k.
+func (ss *ShardedStorager) Shard(k []byte) string {
+	if len(ss.ring) == 0 {
+		return ""
+	}
+
+	if ss.RouteByStructID {
+		k = structID(k)
+	}
+
+	h := ringHash(k)
+	i := sort.Search(len(ss.ring), func(i int) bool {
+		return ss.ring[i].hash >= h
+	})
+
+	if i == len(ss.ring) {
+		i = 0
+	}
+
+	return ss.ring[i].shard
+}
+
+func (ss *ShardedStorager) storager(k []byte) (Storager, error) {
+	s, ok := ss.shards[ss.Shard(k)]
+	if !ok {
+		return nil, errors.New("sharded storager has no shards")
+	}
+
+	return s, nil
+}
+
+func (ss *ShardedStorager) Save(k, v []byte) error {
+	return ss.SaveContext(context.Background(), k, v)
+}
+
+func (ss *ShardedStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	s, err := ss.storager(k)
+	if err != nil {
+		return err
+	}
+
+	return saveContext(ctx, s, k, v)
+}
+
+func (ss *ShardedStorager) Load(k []byte) ([]byte, error) {
+	return ss.LoadContext(context.Background(), k)
+}
+
+func (ss *ShardedStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	s, err := ss.storager(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadContext(ctx, s, k)
+}
+
+func (ss *ShardedStorager) wrapped() []Storager {
+	shards := make([]Storager, 0, len(ss.shards))
+	for _, s := range ss.shards {
+		shards = append(shards, s)
+	}
+
+	return shards
+}
+
+func (ss *ShardedStorager) Delete(k []byte) error {
+	return ss.DeleteContext(context.Background(), k)
+}
+
+func (ss *ShardedStorager) DeleteContext(ctx context.Context, k []byte) error {
+	s, err := ss.storager(k)
+	if err != nil {
+		return err
+	}
+
+	return DeleteContext(ctx, s, k)
+}
+
+// Scan scans every shard and merges their keys in order.
+func (ss *ShardedStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return ss.ScanContext(context.Background(), opts, fn)
+}
+
+func (ss *ShardedStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	type entry struct {
+		k, v []byte
+	}
+
+	entries := []entry{}
+	for _, s := range ss.shards {
+		err := ScanContext(ctx, s, opts, func(k, v []byte) bool {
+			entries = append(entries, entry{k, v})
+			return true
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].k, entries[j].k) < 0
+	})
+
+	for i, e := range entries {
+		if opts.Limit > 0 && i >= opts.Limit {
+			break
+		}
+
+		if !fn(e.k, e.v) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Batch needs every key of ops to live on the same shard, which
+// RouteByStructID ensures for the writes of PersistStruct, and returns
+// ErrBatchUnsupported otherwise.
+func (ss *ShardedStorager) Batch(ops []BatchOp) error {
+	return ss.BatchContext(context.Background(), ops)
+}
+
+func (ss *ShardedStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	shard := ss.Shard(ops[0].Key)
+	for _, op := range ops[1:] {
+		if ss.Shard(op.Key) != shard {
+			return ErrBatchUnsupported
+		}
+	}
+
+	s, err := ss.storager(ops[0].Key)
+	if err != nil {
+		return err
+	}
+
+	return BatchContext(ctx, s, ops)
+}
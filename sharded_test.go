@@ -0,0 +1,127 @@
+package persistent
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardedStorager(t *testing.T) {
+	shards := map[string]Storager{}
+	backends := map[string]*MemoryStorager{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		backends[name] = NewMemoryStorager()
+		shards[name] = backends[name]
+	}
+
+	storager := NewShardedStorager(shards, 64)
+
+	for i := 0; i < 4000; i++ {
+		k := []byte(fmt.Sprintf("key%d", i))
+		if err := storager.Save(k, k); err != nil {
+			t.Fatal(err)
+		}
+
+		dt, err := storager.Load(k)
+		if err != nil || string(dt) != string(k) {
+			t.Fatalf("expected %s, got %q, %v", k, dt, err)
+		}
+	}
+
+	for name, backend := range backends {
+		if backend.Len() < 500 || backend.Len() > 1500 {
+			t.Fatalf("shard %s holds %d of 4000 keys", name, backend.Len())
+		}
+	}
+
+	// removing a shard only moves its own keys
+	delete(shards, "d")
+	smaller := NewShardedStorager(shards, 64)
+
+	for i := 0; i < 4000; i++ {
+		k := []byte(fmt.Sprintf("key%d", i))
+		if before := storager.Shard(k); before != "d" && smaller.Shard(k) != before {
+			t.Fatalf("%s moved from %s to %s", k, before, smaller.Shard(k))
+		}
+	}
+}
+
+func TestShardedStoragerStructID(t *testing.T) {
+	shards := map[string]Storager{}
+	backends := map[string]*MemoryStorager{}
+	for _, name := range []string{"a", "b", "c"} {
+		backends[name] = NewMemoryStorager()
+		shards[name] = backends[name]
+	}
+
+	storager := NewShardedStorager(shards, 32)
+	storager.RouteByStructID = true
+
+	type pessoa struct {
+		Nome   *PersistentString
+		Idade  *PersistentUint32
+		Filhos *PersistentSlice
+	}
+
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("p%d", i)
+		PersistStruct(id, &pessoa{
+			Nome:   NewPersistentString(id),
+			Idade:  NewPersistentUint32(uint32(i)),
+			Filhos: &PersistentSlice{NewPersistentString("Ana"), NewPersistentString("Rui")},
+		}, storager, func(e error) {
+			if e != nil {
+				t.Fatal(e)
+			}
+		})
+
+		// name, age, slice length, slice type and two elements
+		found := 0
+		for _, backend := range backends {
+			if backend.Len() > 0 {
+				found++
+			}
+			if l := backend.Len(); l != 0 && l != 6 {
+				t.Fatalf("the fields of %s were split across shards", id)
+			}
+		}
+
+		if found != 1 {
+			t.Fatalf("the fields of %s were split across shards", id)
+		}
+
+		nP := &pessoa{}
+		RestoreStruct(id, nP, storager, func(e error) {
+			if e != nil {
+				t.Fatal(e)
+			}
+		})
+
+		if string(*nP.Nome) != id || len(*nP.Filhos) != 2 {
+			t.Fail()
+		}
+
+		for name := range backends {
+			backends[name] = NewMemoryStorager()
+			shards[name] = backends[name]
+		}
+	}
+}
+
+func TestStructID(t *testing.T) {
+	keys := [][]byte{
+		append([]byte{PersistentStringPrefix}, "pcarlos/Nome"...),
+		append([]byte{PersistentUint64Prefix, 'l', PersistentSlicePrefix}, "pcarlos/Filhos"...),
+		append([]byte{PersistentBytePrefix, 't', PersistentSlicePrefix}, "pcarlos/Filhos"...),
+		append([]byte{PersistentStringPrefix, PersistentSlicePrefix}, "pcarlos/Filhos:\x0c1"...),
+	}
+
+	for _, k := range keys {
+		if id := structID(k); string(id) != "pcarlos" {
+			t.Fatalf("%q: expected pcarlos, got %q", k, id)
+		}
+	}
+
+	if id := structID(append([]byte{PersistentStringPrefix}, "test"...)); string(id) != "test" {
+		t.Fatalf("expected test, got %q", id)
+	}
+}
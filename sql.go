@@ -0,0 +1,319 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type SQLDialect int
+
+const (
+	SQLiteDialect SQLDialect = iota
+	PostgresDialect
+)
+
+func (d SQLDialect) placeholder(n int) string {
+	if d == PostgresDialect {
+		return fmt.Sprintf("$%d", n)
+	}
+
+	return "?"
+}
+
+func (d SQLDialect) blob() string {
+	if d == PostgresDialect {
+		return "BYTEA"
+	}
+
+	return "BLOB"
+}
+
+func quoteSQLIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// SQLStorager keeps values in a (key, value) table of a database/sql
+// database. The table is created when missing.
+type SQLStorager struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+
+	saveQuery   string
+	loadQuery   string
+	deleteQuery string
+
+	insertQuery     string
+	swapQuery       string
+	swapDeleteQuery string
+}
+
+func NewSQLStorager(db *sql.DB, table string, dialect SQLDialect) (*SQLStorager, error) {
+	ss := &SQLStorager{
+		db:      db,
+		dialect: dialect,
+		table:   quoteSQLIdent(table),
+	}
+
+	_, err := db.Exec(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s ("key" %s PRIMARY KEY, "value" %s)`,
+		ss.table, dialect.blob(), dialect.blob(),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	ss.saveQuery = fmt.Sprintf(
+		`INSERT INTO %s ("key", "value") VALUES (%s, %s) ON CONFLICT ("key") DO UPDATE SET "value" = excluded."value"`,
+		ss.table, dialect.placeholder(1), dialect.placeholder(2),
+	)
+
+	ss.loadQuery = fmt.Sprintf(
+		`SELECT "value" FROM %s WHERE "key" = %s`,
+		ss.table, dialect.placeholder(1),
+	)
+
+	ss.deleteQuery = fmt.Sprintf(
+		`DELETE FROM %s WHERE "key" = %s`,
+		ss.table, dialect.placeholder(1),
+	)
+
+	ss.insertQuery = fmt.Sprintf(
+		`INSERT INTO %s ("key", "value") VALUES (%s, %s) ON CONFLICT ("key") DO NOTHING`,
+		ss.table, dialect.placeholder(1), dialect.placeholder(2),
+	)
+
+	ss.swapQuery = fmt.Sprintf(
+		`UPDATE %s SET "value" = %s WHERE "key" = %s AND "value" = %s`,
+		ss.table, dialect.placeholder(1), dialect.placeholder(2), dialect.placeholder(3),
+	)
+
+	ss.swapDeleteQuery = fmt.Sprintf(
+		`DELETE FROM %s WHERE "key" = %s AND "value" = %s`,
+		ss.table, dialect.placeholder(1), dialect.placeholder(2),
+	)
+
+	return ss, nil
+}
+
+func (ss *SQLStorager) Save(k, v []byte) error {
+	return ss.SaveContext(context.Background(), k, v)
+}
+
+func (ss *SQLStorager) SaveContext(ctx context.Context, k, v []byte) error {
+	if v == nil {
+		v = []byte{}
+	}
+
+	_, err := ss.db.ExecContext(ctx, ss.saveQuery, k, v)
+	return err
+}
+
+func (ss *SQLStorager) Load(k []byte) ([]byte, error) {
+	return ss.LoadContext(context.Background(), k)
+}
+
+func (ss *SQLStorager) LoadContext(ctx context.Context, k []byte) ([]byte, error) {
+	var dt []byte
+
+	err := ss.db.QueryRowContext(ctx, ss.loadQuery, k).Scan(&dt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dt == nil {
+		dt = []byte{}
+	}
+
+	return dt, nil
+}
+
+func (ss *SQLStorager) Delete(k []byte) error {
+	return ss.DeleteContext(context.Background(), k)
+}
+
+func (ss *SQLStorager) DeleteContext(ctx context.Context, k []byte) error {
+	_, err := ss.db.ExecContext(ctx, ss.deleteQuery, k)
+	return err
+}
+
+func (ss *SQLStorager) Scan(opts ScanOptions, fn func(k, v []byte) bool) error {
+	return ss.ScanContext(context.Background(), opts, fn)
+}
+
+// sqlScanPage is how many rows ScanContext reads before calling fn on them.
+const sqlScanPage = 256
+
+// ScanContext relies on the database comparing keys byte by byte, as SQLite
+// and PostgreSQL do for BLOB and BYTEA. The entries are read a page at a time
+// and fn only runs once the page is read, so it may use the storager even
+// with a single connection in the pool.
+func (ss *SQLStorager) ScanContext(ctx context.Context, opts ScanOptions, fn func(k, v []byte) bool) error {
+	start, end := opts.bounds()
+
+	var after []byte
+	for seen := 0; opts.Limit <= 0 || seen < opts.Limit; {
+		limit := sqlScanPage
+		if opts.Limit > 0 && opts.Limit-seen < limit {
+			limit = opts.Limit - seen
+		}
+
+		page, err := ss.scanPage(ctx, opts.KeysOnly, start, after, end, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range page {
+			if !fn(entry[0], entry[1]) {
+				return nil
+			}
+		}
+
+		if len(page) < limit {
+			return nil
+		}
+
+		seen += len(page)
+		// never nil, even for the empty key
+		after = append([]byte{}, page[len(page)-1][0]...)
+	}
+
+	return nil
+}
+
+// scanPage reads up to limit entries from start, or after the key after when
+// set, to end.
+func (ss *SQLStorager) scanPage(ctx context.Context, keysOnly bool, start, after, end []byte, limit int) ([][2][]byte, error) {
+	columns := `"key", "value"`
+	if keysOnly {
+		columns = `"key"`
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s`, columns, ss.table)
+
+	conditions, args := []string{}, []interface{}{}
+	if after != nil {
+		args = append(args, after)
+		conditions = append(conditions, `"key" > `+ss.dialect.placeholder(len(args)))
+	} else if start != nil {
+		args = append(args, start)
+		conditions = append(conditions, `"key" >= `+ss.dialect.placeholder(len(args)))
+	}
+
+	if end != nil {
+		args = append(args, end)
+		conditions = append(conditions, `"key" < `+ss.dialect.placeholder(len(args)))
+	}
+
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query += fmt.Sprintf(` ORDER BY "key" LIMIT %d`, limit)
+
+	rows, err := ss.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := [][2][]byte{}
+	for rows.Next() {
+		var k, v []byte
+
+		dest := []interface{}{&k, &v}
+		if keysOnly {
+			dest = dest[:1]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		// drivers scan empty BLOBs as nil
+		if k == nil {
+			k = []byte{}
+		}
+
+		if !keysOnly && v == nil {
+			v = []byte{}
+		}
+
+		page = append(page, [2][]byte{k, v})
+	}
+
+	return page, rows.Err()
+}
+
+func (ss *SQLStorager) Batch(ops []BatchOp) error {
+	return ss.BatchContext(context.Background(), ops)
+}
+
+// BatchContext runs the ops in a transaction.
+func (ss *SQLStorager) BatchContext(ctx context.Context, ops []BatchOp) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if op.Delete {
+			_, err = tx.ExecContext(ctx, ss.deleteQuery, op.Key)
+		} else {
+			v := op.Value
+			if v == nil {
+				v = []byte{}
+			}
+
+			_, err = tx.ExecContext(ctx, ss.saveQuery, op.Key, v)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (ss *SQLStorager) CompareAndSwap(k, old, new []byte) (bool, error) {
+	return ss.CompareAndSwapContext(context.Background(), k, old, new)
+}
+
+// CompareAndSwapContext runs a single conditional statement, swapped when it
+// affected a row.
+func (ss *SQLStorager) CompareAndSwapContext(ctx context.Context, k, old, new []byte) (bool, error) {
+	var res sql.Result
+	var err error
+
+	switch {
+	case old == nil && new == nil:
+		_, err := ss.LoadContext(ctx, k)
+		if errors.Is(err, ErrNotFound) {
+			return true, nil
+		}
+
+		return false, err
+	case old == nil:
+		res, err = ss.db.ExecContext(ctx, ss.insertQuery, k, new)
+	case new == nil:
+		res, err = ss.db.ExecContext(ctx, ss.swapDeleteQuery, k, old)
+	default:
+		res, err = ss.db.ExecContext(ctx, ss.swapQuery, new, k, old)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,232 @@
+package persistent
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// openTestSQL opens a SQLite database in a temporary directory. SQLite allows
+// one writer at a time, so the pool is kept to a single connection.
+func openTestSQL(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestSQLStorager(t *testing.T) {
+	db := openTestSQL(t)
+
+	// SQLite takes $1 placeholders too, so both dialects run against it
+	for _, dialect := range []SQLDialect{SQLiteDialect, PostgresDialect} {
+		storager, err := NewSQLStorager(db, `test "values"`, dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k := []byte{PersistentStringPrefix, 'p', '/', 0}
+		if err := storager.Save(k, []byte("first")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := storager.Save(k, []byte("second")); err != nil {
+			t.Fatal(err)
+		}
+
+		dt, err := storager.Load(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(dt) != "second" {
+			t.Fatalf("expected second, got %q", dt)
+		}
+
+		if err := storager.Save([]byte("empty"), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		dt, err = storager.Load([]byte("empty"))
+		if err != nil || dt == nil || len(dt) != 0 {
+			t.Fatalf("expected an empty value, got %v, %v", dt, err)
+		}
+
+		if err := storager.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := storager.Load(k); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected the row to be deleted, got %v", err)
+		}
+
+		if _, err := storager.Load([]byte("missing")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		if dialect == PostgresDialect && !strings.Contains(storager.saveQuery, `"test ""values""" ("key", "value") VALUES ($1, $2)`) {
+			t.Fatalf("unexpected postgres query %s", storager.saveQuery)
+		}
+	}
+}
+
+func TestSQLStoragerScan(t *testing.T) {
+	storager, err := NewSQLStorager(openTestSQL(t), "scan", PostgresDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "a/1", "a/2", "a/3", "b"} {
+		storager.Save([]byte(k), []byte("v"+k))
+	}
+
+	keys := ""
+	err = storager.Scan(ScanOptions{Prefix: []byte("a/"), Limit: 2}, func(k, v []byte) bool {
+		if string(v) != "v"+string(k) {
+			t.Fatalf("unexpected value of %q: %q", k, v)
+		}
+
+		keys += string(k) + " "
+		return true
+	})
+
+	if err != nil || keys != "a/1 a/2 " {
+		t.Fatalf("unexpected keys %q, %v", keys, err)
+	}
+
+	keys = ""
+	err = storager.Scan(ScanOptions{Start: []byte("a"), End: []byte("a/3"), KeysOnly: true}, func(k, v []byte) bool {
+		keys += string(k) + " "
+		return true
+	})
+
+	if err != nil || keys != "a a/1 a/2 " {
+		t.Fatalf("unexpected keys %q, %v", keys, err)
+	}
+}
+
+func TestSQLStoragerScanReentrant(t *testing.T) {
+	// the test database has a single connection, which fn needs too
+	storager, err := NewSQLStorager(openTestSQL(t), "pages", SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := sqlScanPage*2 + 10
+	storager.Save([]byte(""), []byte("empty"))
+	for i := 0; i < n; i++ {
+		storager.Save([]byte(fmt.Sprintf("p/%04d", i)), []byte(fmt.Sprint(i)))
+	}
+
+	for _, opts := range []ScanOptions{{Prefix: []byte("p/")}, {}, {Limit: sqlScanPage + 1}} {
+		scanned := 0
+		err = storager.Scan(opts, func(k, v []byte) bool {
+			dt, err := storager.Load(k)
+			if err != nil || string(dt) != string(v) {
+				t.Fatalf("unexpected value of %q: %q, %v", k, dt, err)
+			}
+
+			scanned++
+			return true
+		})
+
+		expected := n
+		switch {
+		case opts.Limit > 0:
+			expected = opts.Limit
+		case opts.Prefix == nil:
+			expected = n + 1
+		}
+
+		if err != nil || scanned != expected {
+			t.Fatalf("%+v: expected %d entries, got %d, %v", opts, expected, scanned, err)
+		}
+	}
+}
+
+func TestSQLStoragerBatch(t *testing.T) {
+	db := openTestSQL(t)
+
+	storager, err := NewSQLStorager(db, "batch", SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storager.Save([]byte("a"), []byte("1"))
+
+	err = storager.Batch([]BatchOp{
+		{Key: []byte("a"), Delete: true},
+		{Key: []byte("b"), Value: []byte("2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TRIGGER "reject" BEFORE INSERT ON "batch" WHEN NEW."key" = X'64'
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storager.Batch([]BatchOp{
+		{Key: []byte("c"), Value: []byte("3")},
+		{Key: []byte("d"), Value: []byte("4")},
+	})
+	if err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+
+	if _, err := storager.Load([]byte("c")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the batch to be rolled back, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("b")); err != nil || string(dt) != "2" {
+		t.Fatalf("expected the first batch to stay, got %q, %v", dt, err)
+	}
+}
+
+func TestSQLStoragerCompareAndSwap(t *testing.T) {
+	storager, err := NewSQLStorager(openTestSQL(t), "swap", PostgresDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		old, new []byte
+		swapped  bool
+	}{
+		{nil, []byte("1"), true},
+		{nil, []byte("2"), false},
+		{[]byte("2"), []byte("3"), false},
+		{[]byte("1"), []byte("3"), true},
+		{[]byte("1"), nil, false},
+		{[]byte("3"), []byte{}, true},
+		{[]byte{}, []byte("4"), true},
+		{[]byte("4"), nil, true},
+		{nil, nil, true},
+	}
+
+	for i, step := range steps {
+		swapped, err := storager.CompareAndSwap([]byte("k"), step.old, step.new)
+		if err != nil || swapped != step.swapped {
+			t.Fatalf("step %d: expected swapped to be %v, got %v, %v", i, step.swapped, swapped, err)
+		}
+	}
+
+	if _, err := storager.Load([]byte("k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected k to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package persistent
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// TraceInfo describes a traced Persist or Restore.
+type TraceInfo struct {
+	// Op is "persist", "restore" or "erase".
+	Op string
+
+	// Key is the key given to Persist or Restore, before the type prefix is
+	// prepended, or the struct id for the struct helpers.
+	Key []byte
+
+	// Type names the value type as PrefixName does, or "struct".
+	Type string
+
+	// Field is the struct field name, set on the spans of the struct helpers.
+	Field string
+}
+
+// TraceSpan is ended once the traced operation returns. Size is the length of
+// the value saved or loaded, zero for slices and structs, which are traced
+// as the parent of the spans of their elements and fields.
+type TraceSpan interface {
+	End(size int, err error)
+}
+
+// Tracer is the hook called around every Persist, Restore and Erase of the
+// Persistent types, PersistentSlice and the struct helpers.
+type Tracer interface {
+	Start(ctx context.Context, info TraceInfo) (context.Context, TraceSpan)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, info TraceInfo) (context.Context, TraceSpan) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(int, error) {}
+
+type tracerHolder struct {
+	Tracer
+}
+
+var tracer atomic.Value
+
+func init() {
+	tracer.Store(tracerHolder{noopTracer{}})
+}
+
+// SetTracer installs the Tracer of the package. A nil Tracer restores the
+// default, which does nothing.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+
+	tracer.Store(tracerHolder{t})
+}
+
+func startSpan(ctx context.Context, info TraceInfo) (context.Context, TraceSpan) {
+	return tracer.Load().(tracerHolder).Start(ctx, info)
+}
+
+// traced runs fn inside a span of the value type named by prefix.
+func traced(ctx context.Context, op string, prefix byte, k []byte, fn func(context.Context) (int, error)) error {
+	ctx, span := startSpan(ctx, TraceInfo{
+		Op:   op,
+		Key:  k,
+		Type: PrefixName([]byte{prefix}),
+	})
+
+	size, err := fn(ctx)
+	span.End(size, err)
+	return err
+}
@@ -0,0 +1,124 @@
+package persistent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	info TraceInfo
+	size int
+	err  error
+}
+
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []recordedSpan
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, info TraceInfo) (context.Context, TraceSpan) {
+	return ctx, &recordingSpan{rt, info}
+}
+
+type recordingSpan struct {
+	rt   *recordingTracer
+	info TraceInfo
+}
+
+func (rs *recordingSpan) End(size int, err error) {
+	rs.rt.lock.Lock()
+	defer rs.rt.lock.Unlock()
+
+	rs.rt.spans = append(rs.rt.spans, recordedSpan{rs.info, size, err})
+}
+
+func (rt *recordingTracer) find(op, key string) (recordedSpan, bool) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	for _, s := range rt.spans {
+		if s.info.Op == op && string(s.info.Key) == key {
+			return s, true
+		}
+	}
+
+	return recordedSpan{}, false
+}
+
+func TestTracer(t *testing.T) {
+	rt := new(recordingTracer)
+	SetTracer(rt)
+	defer SetTracer(nil)
+
+	s := NewMemoryStorager()
+
+	if err := NewPersistentInt32(32).Persist(s, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if span, ok := rt.find("persist", "a"); !ok || span.info.Type != "int32" || span.size != 4 || span.err != nil {
+		t.Fatalf("unexpected span %+v", span)
+	}
+
+	if err := EmptyPersistentString().Restore(s, []byte("missing")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if span, ok := rt.find("restore", "missing"); !ok || !errors.Is(span.err, ErrNotFound) {
+		t.Fatalf("unexpected span %+v", span)
+	}
+
+	slc := PersistentSlice{NewPersistentString("a"), NewPersistentString("bc")}
+	if err := slc.Persist(s, []byte("slc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if span, ok := rt.find("persist", "slc"); !ok || span.info.Type != "slice" || span.size != 0 {
+		t.Fatalf("unexpected span %+v", span)
+	}
+
+	if span, ok := rt.find("persist", "\x0dslc:\x0c1"); !ok || span.size != 2 {
+		t.Fatalf("unexpected element span %+v", span)
+	}
+}
+
+func TestTracerStruct(t *testing.T) {
+	rt := new(recordingTracer)
+	SetTracer(rt)
+	defer SetTracer(nil)
+
+	type pessoa struct {
+		Nome  *PersistentString
+		Idade *PersistentUint32
+	}
+
+	s := NewMemoryStorager()
+	NewPersistentString("Carlos").Persist(s, []byte("pcarlos/Nome"))
+
+	RestoreStruct("pcarlos", &pessoa{}, s, func(error) {})
+
+	span, ok := rt.find("restore", "pcarlos")
+	if !ok || span.info.Type != "struct" || !errors.Is(span.err, ErrNotFound) {
+		t.Fatalf("unexpected struct span %+v", span)
+	}
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	fields := map[string]recordedSpan{}
+	for _, s := range rt.spans {
+		if s.info.Field != "" {
+			fields[s.info.Field] = s
+		}
+	}
+
+	if f := fields["Nome"]; string(f.info.Key) != "pcarlos/Nome" || f.info.Type != "string" || f.err != nil {
+		t.Fatalf("unexpected field span %+v", f)
+	}
+
+	if f := fields["Idade"]; f.info.Type != "uint32" || !errors.Is(f.err, ErrNotFound) {
+		t.Fatalf("unexpected field span %+v", f)
+	}
+}
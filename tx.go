@@ -0,0 +1,115 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// ErrCompareAndSwapUnsupported is returned when swapping through a Storager
+// that does not implement CompareAndSwapper.
+var ErrCompareAndSwapUnsupported = errors.New("storager does not support CompareAndSwap")
+
+// ErrConflict is returned by Commit when another writer changed a key the
+// transaction read or wrote. Running the transaction again may succeed.
+var ErrConflict = errors.New("transaction conflict")
+
+// ErrTxDone is returned when using a transaction after Commit or Rollback.
+var ErrTxDone = errors.New("transaction is already committed or rolled back")
+
+// CompareAndSwapper is implemented by the Storagers able to replace the value
+// of a key only while it still is old. A nil old stands for a missing key and
+// a nil new deletes the key. swapped is false, with no error, when the value
+// was not old.
+type CompareAndSwapper interface {
+	CompareAndSwap(k, old, new []byte) (swapped bool, err error)
+}
+
+// ContextCompareAndSwapper is a CompareAndSwapper whose calls honor the
+// cancellation and deadline of a context.
+type ContextCompareAndSwapper interface {
+	CompareAndSwapContext(ctx context.Context, k, old, new []byte) (swapped bool, err error)
+}
+
+// CompareAndSwap replaces the value of k on s with new while it is old.
+func CompareAndSwap(s Storager, k, old, new []byte) (bool, error) {
+	return CompareAndSwapContext(context.Background(), s, k, old, new)
+}
+
+// CompareAndSwapContext replaces the value of k on s with new while it is
+// old, adapting a CompareAndSwapper that takes no context as WithContext
+// does.
+func CompareAndSwapContext(ctx context.Context, s Storager, k, old, new []byte) (bool, error) {
+	if cs, ok := s.(ContextCompareAndSwapper); ok {
+		return cs.CompareAndSwapContext(ctx, k, old, new)
+	}
+
+	cs, ok := s.(CompareAndSwapper)
+	if !ok {
+		return false, ErrCompareAndSwapUnsupported
+	}
+
+	var swapped bool
+	err := runContext(ctx, func() error {
+		var err error
+		swapped, err = cs.CompareAndSwap(k, old, new)
+		return err
+	})
+
+	return swapped, err
+}
+
+func isCompareAndSwapper(s Storager) bool {
+	switch s.(type) {
+	case CompareAndSwapper, ContextCompareAndSwapper:
+		return true
+	}
+
+	return false
+}
+
+// casMatches reports whether cur, the value of a key or nil when it is
+// missing, is the old value expected by a CompareAndSwap.
+func casMatches(cur, old []byte) bool {
+	if cur == nil || old == nil {
+		return cur == nil && old == nil
+	}
+
+	return bytes.Equal(cur, old)
+}
+
+// Tx is a transaction over a Storager. Its Saves and Deletes are buffered
+// until Commit, and its Loads see them.
+type Tx interface {
+	Storager
+	Delete(k []byte) error
+	Commit() error
+	Rollback() error
+}
+
+// Transactional is implemented by the Storagers able to run transactions.
+type Transactional interface {
+	Begin() (Tx, error)
+}
+
+// RunTx runs fn in a transaction of t and commits it. On ErrConflict, fn is
+// run again in a new transaction, up to attempts times in all. When fn fails
+// the transaction is rolled back and its error returned.
+func RunTx(t Transactional, attempts int, fn func(tx Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		tx, err := t.Begin()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if !errors.Is(err, ErrConflict) || attempt >= attempts {
+			return err
+		}
+	}
+}
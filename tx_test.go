@@ -0,0 +1,93 @@
+package persistent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	storager := NewMemoryStorager()
+
+	if swapped, err := CompareAndSwap(storager, []byte("a"), nil, []byte("1")); err != nil || !swapped {
+		t.Fatalf("expected a missing key to be swapped, got %v, %v", swapped, err)
+	}
+
+	if swapped, err := CompareAndSwap(storager, []byte("a"), []byte("2"), []byte("3")); err != nil || swapped {
+		t.Fatalf("expected a changed key not to be swapped, got %v, %v", swapped, err)
+	}
+
+	if swapped, err := CompareAndSwap(storager, []byte("a"), []byte("1"), nil); err != nil || !swapped {
+		t.Fatalf("expected the key to be deleted, got %v, %v", swapped, err)
+	}
+
+	if _, err := storager.Load([]byte("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a to be deleted, got %v", err)
+	}
+
+	if _, err := CompareAndSwap(&testStorager{map[string][]byte{}}, []byte("a"), nil, nil); !errors.Is(err, ErrCompareAndSwapUnsupported) {
+		t.Fatalf("expected ErrCompareAndSwapUnsupported, got %v", err)
+	}
+}
+
+// conflictingTx fails its first commits with ErrConflict.
+type conflictingTx struct {
+	Tx
+	conflicts *int
+}
+
+func (tx *conflictingTx) Commit() error {
+	if *tx.conflicts > 0 {
+		*tx.conflicts--
+		return ErrConflict
+	}
+
+	return tx.Tx.Commit()
+}
+
+type conflictingTransactional struct {
+	t         Transactional
+	conflicts int
+}
+
+func (ct *conflictingTransactional) Begin() (Tx, error) {
+	tx, err := ct.t.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &conflictingTx{tx, &ct.conflicts}, nil
+}
+
+func TestRunTx(t *testing.T) {
+	storager := NewOptimisticStorager(NewMemoryStorager())
+	ct := &conflictingTransactional{t: storager, conflicts: 2}
+
+	runs := 0
+	err := RunTx(ct, 3, func(tx Tx) error {
+		runs++
+		return tx.Save([]byte("a"), []byte("1"))
+	})
+
+	if err != nil || runs != 3 {
+		t.Fatalf("expected the tx to be run again on conflicts, got %d runs, %v", runs, err)
+	}
+
+	ct.conflicts = 2
+	if err := RunTx(ct, 2, func(tx Tx) error { return nil }); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict once attempts run out, got %v", err)
+	}
+
+	failure := errors.New("failure")
+	err = RunTx(storager, 3, func(tx Tx) error {
+		tx.Save([]byte("a"), []byte("2"))
+		return failure
+	})
+
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+
+	if dt, err := storager.Load([]byte("a")); err != nil || string(dt) != "1" {
+		t.Fatalf("expected the failed tx to be rolled back, got %q, %v", dt, err)
+	}
+}